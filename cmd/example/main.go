@@ -62,25 +62,9 @@ func (r *ImageRenderer) Draw(verts []fontstash.Vertex) {
 		sMinX := int(v0.U * texW)
 		sMinY := int(v0.V * texH)
 
-		c := v0.Color
-		col := color.RGBA{
-			R: uint8(c), // R is LSB in C code usually for LE?
-			G: uint8(c >> 8),
-			B: uint8(c >> 16),
-			A: uint8(c >> 24),
-		}
-		// Wait, in previous example I assumed Big Endian (RRGGBBAA)?
-		// The C code uses:
-		// unsigned int white = glfonsRGBA(255,255,255,255);
-		// #define glfonsRGBA(r, g, b, a) (((unsigned int)r) | ((unsigned int)g << 8) | ((unsigned int)b << 16) | ((unsigned int)a << 24))
-		// This creates ABGR (little endian uint32).
-		// So R is lowest byte.
-		// My previous example parsed as RRGGBBAA (Big Endian).
-		// Let's use Little Endian ABGR as per C convention if we want 1:1.
-		// R = c & 0xFF
-		// G = (c >> 8) & 0xFF
-		// B = (c >> 16) & 0xFF
-		// A = (c >> 24) & 0xFF
+		// Vertex colors are 0xAABBGGRR with straight alpha.
+		cr, cg, cb, ca := fontstash.UnpackRGBA(v0.Color)
+		col := color.NRGBA{R: cr, G: cg, B: cb, A: ca}
 
 		src := image.NewUniform(col)
 
@@ -117,14 +101,10 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Helper color
-	rgba := func(r, g, b, a uint8) uint32 {
-		return uint32(r) | (uint32(g) << 8) | (uint32(b) << 16) | (uint32(a) << 24)
-	}
-	white := rgba(255, 255, 255, 255)
-	brown := rgba(192, 128, 0, 255)
-	blue := rgba(0, 192, 255, 255)
-	black := rgba(0, 0, 0, 255)
+	white := fontstash.RGBA(255, 255, 255, 255)
+	brown := fontstash.RGBA(192, 128, 0, 255)
+	blue := fontstash.RGBA(0, 192, 255, 255)
+	black := fontstash.RGBA(0, 0, 0, 255)
 
 	_ = black // unused
 
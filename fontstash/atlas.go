@@ -1,5 +1,7 @@
 package fontstash
 
+import "slices"
+
 type atlasNode struct {
 	x, y, width int16
 }
@@ -27,24 +29,11 @@ func newAtlas(w, h, nnodes int) *Atlas {
 }
 
 func (a *Atlas) insertNode(idx, x, y, w int) {
-	// Insert node
-	// In Go, we can use slice manipulation
-	node := atlasNode{
+	a.nodes = slices.Insert(a.nodes, idx, atlasNode{
 		x:     int16(x),
 		y:     int16(y),
 		width: int16(w),
-	}
-
-	a.nodes = append(a.nodes, atlasNode{}) // Grow slice
-	copy(a.nodes[idx+1:], a.nodes[idx:])
-	a.nodes[idx] = node
-}
-
-func (a *Atlas) removeNode(idx int) {
-	if len(a.nodes) == 0 {
-		return
-	}
-	a.nodes = append(a.nodes[:idx], a.nodes[idx+1:]...)
+	})
 }
 
 func (a *Atlas) expand(w, h int) {
@@ -73,43 +62,38 @@ func (a *Atlas) addSkylineLevel(idx, x, y, w, h int) bool {
 	// Insert new node
 	a.insertNode(idx, x, y+h, w)
 
-	// Delete skyline segments that fall under the shadow of the new segment.
-	for i := idx + 1; i < len(a.nodes); i++ {
-		prev := a.nodes[i-1]
-		curr := a.nodes[i]
-
-		if curr.x < prev.x+prev.width {
-			shrink := int(prev.x + prev.width - curr.x)
-			a.nodes[i].x += int16(shrink)
-			a.nodes[i].width -= int16(shrink)
-
-			if a.nodes[i].width <= 0 {
-				a.removeNode(i)
-				i--
-			} else {
-				break
-			}
-		} else {
+	// Delete skyline segments that fall under the shadow of the new
+	// segment, trimming the first one that sticks out past it.
+	end := idx + 1
+	right := a.nodes[idx].x + a.nodes[idx].width
+	for ; end < len(a.nodes) && a.nodes[end].x < right; end++ {
+		if n := &a.nodes[end]; n.x+n.width > right {
+			n.width -= right - n.x
+			n.x = right
 			break
 		}
 	}
+	a.nodes = slices.Delete(a.nodes, idx+1, end)
 
 	// Merge same height skyline segments that are next to each other.
-	for i := 0; i < len(a.nodes)-1; i++ {
-		if a.nodes[i].y == a.nodes[i+1].y {
-			a.nodes[i].width += a.nodes[i+1].width
-			a.removeNode(i + 1)
-			i--
+	merged := a.nodes[:1]
+	for _, n := range a.nodes[1:] {
+		if last := &merged[len(merged)-1]; last.y == n.y {
+			last.width += n.width
+		} else {
+			merged = append(merged, n)
 		}
 	}
+	a.nodes = merged
 
 	return true
 }
 
-func (a *Atlas) rectFits(i, w, h int) int {
+func (a *Atlas) rectFits(i, w, h, limit int) int {
 	// Checks if there is enough space at the location of skyline span 'i',
 	// and return the max height of all skyline spans under that at that location,
-	// (think tetris block being dropped at that position). Or -1 if no space found.
+	// (think tetris block being dropped at that position). Or -1 if no space found
+	// with the top of the rect at or below limit.
 	x := int(a.nodes[i].x)
 	y := int(a.nodes[i].y)
 
@@ -123,7 +107,7 @@ func (a *Atlas) rectFits(i, w, h int) int {
 			return -1
 		}
 		y = maxInt(y, int(a.nodes[i].y))
-		if y+h > a.height {
+		if y+h > limit {
 			return -1
 		}
 		spaceLeft -= int(a.nodes[i].width)
@@ -141,7 +125,9 @@ func (a *Atlas) addRect(rw, rh int) (rx, ry int, ok bool) {
 
 	// Bottom left fit heuristic.
 	for i := 0; i < len(a.nodes); i++ {
-		y := a.rectFits(i, rw, rh)
+		// Spans that would end higher than the best so far can't win,
+		// so stop measuring them early.
+		y := a.rectFits(i, rw, rh, besth)
 		if y != -1 {
 			// Score based on height and then width
 			if y+rh < besth || (y+rh == besth && int(a.nodes[i].width) < bestw) {
@@ -27,6 +27,29 @@ func BenchmarkDrawText(b *testing.B) {
 	}
 }
 
+func BenchmarkDrawTextBytes(b *testing.B) {
+	mock := &MockRenderer{}
+	fs, _ := New(Params{
+		Width:    1024,
+		Height:   1024,
+		Renderer: mock,
+	})
+	fontNormal, _ := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	fs.SetFont(fontNormal)
+	fs.SetSize(24.0)
+	fs.SetColor(0xffffffff)
+
+	buf := []byte("The quick brown fox jumps over the lazy dog. 1234567890!@#$%^&*()")
+	// Warm up to ensure glyphs are loaded
+	fs.DrawTextBytes(0, 0, buf)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fs.DrawTextBytes(10, 10, buf)
+	}
+}
+
 func BenchmarkTextBounds(b *testing.B) {
 	mock := &MockRenderer{}
 	fs, _ := New(Params{
@@ -49,3 +72,76 @@ func BenchmarkTextBounds(b *testing.B) {
 		fs.TextBounds(10, 10, s, &bounds)
 	}
 }
+
+func BenchmarkRasterizeGlyphs(b *testing.B) {
+	mock := &MockRenderer{}
+	fs, _ := New(Params{
+		Width:    512,
+		Height:   512,
+		Renderer: mock,
+	})
+	fontNormal, _ := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	fs.SetFont(fontNormal)
+	fs.SetSize(48.0)
+
+	s := "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		// Drop the cache so every glyph is rasterized again.
+		fs.ResetAtlas(512, 512)
+		fs.DrawText(10, 60, s)
+	}
+}
+
+func BenchmarkAtlasPack(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		// 10k small glyph cells of varied sizes leave a long, ragged
+		// skyline.
+		a := newAtlas(4096, 4096, initAtlasNodes)
+		for j := range 10000 {
+			if _, _, ok := a.addRect(6+j%11, 8+j%13); !ok {
+				b.Fatalf("Atlas full after %d rects", j)
+			}
+		}
+	}
+}
+
+// BenchmarkGlyphMiss measures rasterizing and packing a glyph that isn't
+// cached, with the face kept open between misses and, for comparison, with
+// a new face for every miss as before faces were cached.
+func BenchmarkGlyphMiss(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		close bool
+	}{
+		{"CachedFace", false},
+		{"NewFacePerMiss", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			fs, _ := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}})
+			fontNormal, _ := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+			f := fs.Fonts[fontNormal]
+			isize := fs.sizeKey(24)
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				// Forget the glyph in place, so only the miss allocates.
+				f.Glyphs = f.Glyphs[:0]
+				for j := range f.Lut {
+					f.Lut[j] = -1
+				}
+				fs.Atlas.reset(512, 512)
+				if bc.close {
+					fs.CloseFaces()
+				}
+				if _, err := fs.getGlyph(f, 'g', isize, 0, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
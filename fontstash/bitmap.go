@@ -0,0 +1,126 @@
+package fontstash
+
+import (
+	"image"
+)
+
+// GlyphBitmap rasterizes the glyph into the atlas if it isn't cached yet
+// and returns a copy of its atlas cell, padding included, along with the
+// glyph's metrics. Size and blur are interpreted as in SetSize and SetBlur.
+// Whitespace glyphs have an empty bitmap.
+func (fs *FontStash) GlyphBitmap(fontHandle int, codepoint rune, size, blur float32) (*image.Alpha, Glyph, error) {
+	f := fs.getFont(fontHandle)
+	if f == nil {
+		return nil, Glyph{}, ErrInvalidFont
+	}
+	if fs.Params.MeasureOnly {
+		return nil, Glyph{}, ErrMeasureOnly
+	}
+	g, err := fs.getGlyph(f, codepoint, fs.sizeKey(size), int16(blur), 0)
+	if err != nil {
+		return nil, Glyph{}, err
+	}
+	if g == nil {
+		return image.NewAlpha(image.Rectangle{}), Glyph{}, nil
+	}
+
+	w, h := int(g.X1-g.X0), int(g.Y1-g.Y0)
+	img := image.NewAlpha(image.Rect(0, 0, w, h))
+	tex := fs.pageTex(int(g.Page))
+	for y := 0; y < h; y++ {
+		row := (int(g.Y0)+y)*fs.Width + int(g.X0)
+		copy(img.Pix[y*img.Stride:y*img.Stride+w], tex[row:row+w])
+	}
+	return img, *g, nil
+}
+
+// GlyphCoverage rasterizes the glyph without touching the atlas or the
+// glyph cache and returns its coverage, one alpha byte per pixel row by
+// row, with its width and height. The bitmap is the glyph's ink box alone:
+// no padding, blur or Params.Gamma is applied. Size is interpreted as in
+// SetSize, and the glyph is resolved through the fallback chain as when
+// drawing. Whitespace glyphs, and codepoints that draw nothing under
+// Params.MissingGlyphAdvance, have an empty bitmap. Errors are returned
+// rather than passed to the error callback.
+func (fs *FontStash) GlyphCoverage(fontHandle int, codepoint rune, size float32) ([]byte, int, int, error) {
+	f := fs.getFont(fontHandle)
+	if f == nil {
+		return nil, 0, 0, ErrInvalidFont
+	}
+	if fs.Params.MeasureOnly {
+		return nil, 0, 0, ErrMeasureOnly
+	}
+	isize := fs.sizeKey(size)
+	if isize < minFontSize {
+		return nil, 0, 0, nil
+	}
+	renderFont, gIndex := fs.resolveGlyph(f, codepoint)
+	if gIndex == 0 {
+		renderFont = f
+	}
+	if fs.zeroAdvance(codepoint, gIndex) {
+		return nil, 0, 0, nil
+	}
+	// A glyph that can't be decoded comes back as the missing glyph box,
+	// as it is drawn.
+	dr, mask, maskp, _, _, err := fs.rasterizeGlyph(f, renderFont, codepoint, gIndex, isize, 0)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if dr.Empty() || mask == nil {
+		return nil, 0, 0, nil
+	}
+
+	w, h := dr.Dx(), dr.Dy()
+	pix := make([]byte, w*h)
+	copyMask(pix, w, mask, maskp, w, h, nil)
+	return pix, w, h, nil
+}
+
+// AtlasImage returns the atlas texture as an image. The image aliases
+// TexData without copying, so it reflects glyphs added by later draws but
+// must be fetched again after the atlas is expanded or reset, which
+// replace the backing slice. Copy it if a stable snapshot is needed. It is
+// empty in measure-only mode. With several atlas pages it is the first;
+// see AtlasPageImage.
+func (fs *FontStash) AtlasImage() *image.Alpha {
+	if fs.Params.MeasureOnly {
+		return image.NewAlpha(image.Rectangle{})
+	}
+	return &image.Alpha{
+		Pix:    fs.TexData,
+		Stride: fs.Width,
+		Rect:   image.Rect(0, 0, fs.Width, fs.Height),
+	}
+}
+
+// ReserveRect packs a w by h region into the atlas alongside the glyphs and
+// returns its position, or false if the atlas is full. Fill it with
+// WriteRegion to draw icons or custom glyphs from the same texture and
+// batch as text. Reserved regions are not tracked as glyphs: they are never
+// evicted and are lost when the atlas is reset.
+func (fs *FontStash) ReserveRect(w, h int) (x, y int, ok bool) {
+	if w <= 0 || h <= 0 || fs.Params.MeasureOnly {
+		return 0, 0, false
+	}
+	return fs.Atlas.addRect(w, h)
+}
+
+// WriteRegion copies a w by h block of alpha values, stored row by row,
+// into the atlas at x, y and marks it to be uploaded on the next flush.
+// It returns ErrOutOfBounds if the region does not fit in the atlas or
+// alpha holds fewer than w*h values, and ErrMeasureOnly if there is no
+// atlas.
+func (fs *FontStash) WriteRegion(x, y, w, h int, alpha []byte) error {
+	if fs.Params.MeasureOnly {
+		return ErrMeasureOnly
+	}
+	if x < 0 || y < 0 || w < 0 || h < 0 || x+w > fs.Width || y+h > fs.Height || len(alpha) < w*h {
+		return ErrOutOfBounds
+	}
+	for row := 0; row < h; row++ {
+		copy(fs.TexData[(y+row)*fs.Width+x:], alpha[row*w:(row+1)*w])
+	}
+	fs.markDirty(x, y, w, h)
+	return nil
+}
@@ -0,0 +1,520 @@
+package fontstash
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestGlyphBitmap(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	img, g, err := fs.GlyphBitmap(fontNormal, 'A', 32, 0)
+	if err != nil {
+		t.Fatalf("GlyphBitmap: %v", err)
+	}
+	if g.Codepoint != 'A' || g.Size != 320 {
+		t.Errorf("Unexpected glyph %+v", g)
+	}
+	if img.Bounds().Dx() != int(g.X1-g.X0) || img.Bounds().Dy() != int(g.Y1-g.Y0) {
+		t.Errorf("Bitmap size %v does not match glyph cell", img.Bounds())
+	}
+	var ink int
+	for _, p := range img.Pix {
+		if p != 0 {
+			ink++
+		}
+	}
+	if ink == 0 {
+		t.Errorf("Expected glyph bitmap to have coverage")
+	}
+
+	// The glyph is cached, so a second call doesn't rasterize again.
+	if _, _, err := fs.GlyphBitmap(fontNormal, 'A', 32, 0); err != nil || len(fs.Fonts[fontNormal].Glyphs) != 1 {
+		t.Errorf("Expected cached glyph to be reused, have %d glyphs", len(fs.Fonts[fontNormal].Glyphs))
+	}
+
+	if _, _, err := fs.GlyphBitmap(42, 'A', 32, 0); err != ErrInvalidFont {
+		t.Errorf("Expected ErrInvalidFont, got %v", err)
+	}
+}
+
+func TestAtlasImage(t *testing.T) {
+	fs, err := New(Params{Width: 128, Height: 64, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	img := fs.AtlasImage()
+	if img.Bounds().Dx() != 128 || img.Bounds().Dy() != 64 {
+		t.Fatalf("Unexpected atlas bounds %v", img.Bounds())
+	}
+
+	fs.SetFont(fontNormal)
+	fs.DrawText(0, 0, "a")
+	g := fs.Fonts[fontNormal].Glyphs[0]
+	var ink int
+	for y := int(g.Y0); y < int(g.Y1); y++ {
+		for x := int(g.X0); x < int(g.X1); x++ {
+			if img.AlphaAt(x, y).A != 0 {
+				ink++
+			}
+		}
+	}
+	if ink == 0 {
+		t.Errorf("Expected the aliased atlas image to show the new glyph")
+	}
+}
+
+func TestShowMissingGlyph(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, ShowMissingGlyph: true})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	img, g, err := fs.GlyphBitmap(fontNormal, 'あ', 32, 0)
+	if err != nil {
+		t.Fatalf("GlyphBitmap: %v", err)
+	}
+	if g.Index != 0 || g.XAdv <= 0 {
+		t.Errorf("Expected a missing glyph with an advance, got %+v", g)
+	}
+
+	// The outline is inked at its edge and hollow in the middle.
+	pad := fs.Params.GlyphPadding
+	b := img.Bounds()
+	if img.AlphaAt(pad, pad).A != 0xff {
+		t.Errorf("Expected box outline at the top-left corner")
+	}
+	if img.AlphaAt(b.Dx()/2, b.Dy()/2).A != 0 {
+		t.Errorf("Expected the box to be hollow")
+	}
+}
+
+func TestUndecodableGlyphDrawsTofu(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	// Hiding the glyf table leaves a font that maps codepoints but can't
+	// load their outlines, like a color bitmap emoji font.
+	data := bytes.Clone(goregular.TTF)
+	i := bytes.Index(data[:512], []byte("glyf"))
+	copy(data[i:], "glyz")
+	broken, err := fs.AddFontFromBytes("broken", data)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	img, g, err := fs.GlyphBitmap(broken, 'A', 32, 0)
+	if err != nil {
+		t.Fatalf("GlyphBitmap: %v", err)
+	}
+	if g.Index == 0 || g.XAdv <= 0 {
+		t.Errorf("Expected a mapped glyph with an advance, got %+v", g)
+	}
+	pad := fs.Params.GlyphPadding
+	if img.AlphaAt(pad, pad).A != 0xff {
+		t.Errorf("Expected a tofu box for an undecodable glyph")
+	}
+}
+
+func TestReserveAndWriteRegion(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 64, Height: 64, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fs.Flush()
+	mock.Rects = nil
+
+	x, y, ok := fs.ReserveRect(4, 3)
+	if !ok {
+		t.Fatalf("ReserveRect failed")
+	}
+	if wr := fs.whiteRect; image.Rect(x, y, x+4, y+3).Overlaps(wr) {
+		t.Errorf("Reserved rect overlaps the white rect %v", wr)
+	}
+	icon := make([]byte, 4*3)
+	for i := range icon {
+		icon[i] = byte(i + 1)
+	}
+	if err := fs.WriteRegion(x, y, 4, 3, icon); err != nil {
+		t.Fatalf("WriteRegion: %v", err)
+	}
+	if got := fs.TexData[(y+2)*fs.Width+x+3]; got != 12 {
+		t.Errorf("Expected last texel 12, got %d", got)
+	}
+	fs.Flush()
+	if len(mock.Rects) != 1 || mock.Rects[0] != image.Rect(x, y, x+4, y+3) {
+		t.Errorf("Expected region upload, got %v", mock.Rects)
+	}
+
+	if err := fs.WriteRegion(62, 0, 4, 3, icon); err != ErrOutOfBounds {
+		t.Errorf("Expected ErrOutOfBounds past the edge, got %v", err)
+	}
+	if err := fs.WriteRegion(0, 0, 4, 4, icon); err != ErrOutOfBounds {
+		t.Errorf("Expected ErrOutOfBounds for short data, got %v", err)
+	}
+	if _, _, ok := fs.ReserveRect(128, 1); ok {
+		t.Errorf("Expected a rect wider than the atlas to be refused")
+	}
+}
+
+type blockRasterizer struct {
+	calls int
+}
+
+func (r *blockRasterizer) Rasterize(font *Font, codepoint rune, size float64) (*image.Alpha, int, int, int, error) {
+	r.calls++
+	if codepoint == ' ' {
+		return nil, 5 * 64, 0, 0, nil
+	}
+	img := image.NewAlpha(image.Rect(0, 0, 3, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	return img, 10 * 64, 1, -4, nil
+}
+
+func TestCustomRasterizer(t *testing.T) {
+	raster := &blockRasterizer{}
+	fs, err := New(Params{Width: 64, Height: 64, Renderer: &MockRenderer{}, Rasterizer: raster})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	img, g, err := fs.GlyphBitmap(fontNormal, 'x', 16, 0)
+	if err != nil {
+		t.Fatalf("GlyphBitmap: %v", err)
+	}
+	pad := fs.Params.GlyphPadding
+	if g.XAdv != 10*sizeScale || g.XOff != int32(1-pad) || g.YOff != int32(-4-pad) {
+		t.Errorf("Unexpected glyph metrics %+v", g)
+	}
+	if b := img.Bounds(); b.Dx() != 3+2*pad || b.Dy() != 4+2*pad {
+		t.Errorf("Expected a padded 3x4 cell, got %v", b)
+	}
+	if img.AlphaAt(pad, pad).A != 0xff || img.AlphaAt(0, 0).A != 0 {
+		t.Errorf("Expected the block inside the padding")
+	}
+
+	fs.SetFont(fontNormal)
+	fs.SetSize(16)
+	if adv := fs.TextBounds(0, 0, "x x", nil); adv != 25 {
+		t.Errorf("Expected advance 25 from the rasterizer, got %f", adv)
+	}
+	if raster.calls != 2 {
+		t.Errorf("Expected cached glyphs to be reused, got %d calls", raster.calls)
+	}
+}
+
+type failingRasterizer struct{ err error }
+
+func (r failingRasterizer) Rasterize(font *Font, codepoint rune, size float64) (*image.Alpha, int, int, int, error) {
+	return nil, 0, 0, 0, r.err
+}
+
+func TestRasterErrorsReachCallback(t *testing.T) {
+	var errs []error
+	onError := func(err error) { errs = append(errs, err) }
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, ErrorCallback: onError})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	data := bytes.Clone(goregular.TTF)
+	i := bytes.Index(data[:512], []byte("glyf"))
+	copy(data[i:], "glyz")
+	broken, err := fs.AddFontFromBytes("broken", data)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	// The undecodable glyph still draws as tofu, but is reported.
+	fs.SetFont(broken)
+	fs.DrawText(0, 20, "A")
+	if len(errs) != 1 || !errors.Is(errs[0], ErrRasterize) || !strings.Contains(errs[0].Error(), "U+0041") {
+		t.Errorf("Expected one ErrRasterize for U+0041, got %v", errs)
+	}
+
+	errs = nil
+	boom := errors.New("boom")
+	fs, err = New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, ErrorCallback: onError, Rasterizer: failingRasterizer{boom}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	font, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	_, _, err = fs.GlyphBitmap(font, 'A', 20, 0)
+	if !errors.Is(err, ErrRasterize) || !errors.Is(err, boom) {
+		t.Errorf("Expected the returned error to wrap both causes, got %v", err)
+	}
+	if len(errs) != 1 || errs[0] != err {
+		t.Errorf("Expected the callback to see the returned error, got %v", errs)
+	}
+}
+
+func TestNegativeBlurStaysInAtlas(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	// A negative blur would make the padding, and so the write offset
+	// into the cell, negative.
+	img, g, err := fs.GlyphBitmap(font, 'A', 24, -30)
+	if err != nil {
+		t.Fatalf("GlyphBitmap: %v", err)
+	}
+	want, _, _ := fs.GlyphBitmap(font, 'A', 24, 0)
+	if g.Blur != 0 || !bytes.Equal(img.Pix, want.Pix) || len(fs.Fonts[font].Glyphs) != 1 {
+		t.Errorf("Expected a negative blur to be treated as none, got %+v", g)
+	}
+
+	fs.SetFont(font)
+	fs.SetBlur(-3)
+	fs.DrawText(0, 30, "Ag")
+}
+
+func TestDebugDrawAtlasBounds(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: rec})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(30)
+	fs.DrawText(0, 40, "Wiggly")
+	rec.Vertices = rec.Vertices[:0]
+
+	occupied, top := 0, 0
+	for _, n := range fs.Atlas.nodes {
+		if n.y > 0 {
+			occupied++
+			top = max(top, int(n.y))
+		}
+	}
+	fs.DebugDrawAtlasBounds(0xff0000ff)
+	fs.Flush()
+	if want := occupied * 3 * vertsPerQuad; len(rec.Vertices) != want {
+		t.Fatalf("Expected %d verts for %d nodes, got %d", want, occupied, len(rec.Vertices))
+	}
+	u, v := fs.WhiteRectUV()
+	for _, vert := range rec.Vertices {
+		if vert.X < 0 || vert.X > 256 || vert.Y < 0 || vert.Y > float32(top) {
+			t.Fatalf("Vertex %+v outside the filled atlas", vert)
+		}
+		if vert.U != u || vert.V != v || vert.Color != 0xff0000ff {
+			t.Fatalf("Expected a white rect vertex, got %+v", vert)
+		}
+	}
+}
+
+func TestUncoveredCodepoint(t *testing.T) {
+	var cells []Glyph
+	for _, measureOnly := range []bool{false, true} {
+		mock := &MockRenderer{}
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: mock, MeasureOnly: measureOnly})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		f := fs.Fonts[fontNormal]
+
+		// By default, with no fallback, the codepoint is cached as an
+		// empty glyph with no advance, not a leftover value.
+		g, err := fs.getGlyph(f, 'あ', 320, 0, 0)
+		if err != nil || g == nil {
+			t.Fatalf("MeasureOnly %v: getGlyph = %v, %v", measureOnly, g, err)
+		}
+		if g.Index != 0 || g.XAdv != 0 || !g.empty() {
+			t.Errorf("MeasureOnly %v: expected an empty glyph with no advance, got %+v", measureOnly, g)
+		}
+		if m, ok := fs.measureGlyph(f, 'い', 320, 0, 0); !ok || m.XAdv != 0 {
+			t.Errorf("MeasureOnly %v: expected to measure no advance, got %+v, %v", measureOnly, m, ok)
+		}
+		fs.DrawText(10, 30, "あ")
+		if mock.Verts != 0 {
+			t.Errorf("MeasureOnly %v: expected nothing drawn, got %d verts", measureOnly, mock.Verts)
+		}
+
+		// With NotdefAdvance it takes the .notdef glyph and its advance.
+		fs.Params.MissingGlyphAdvance = NotdefAdvance
+		g, err = fs.getGlyph(f, 'う', 320, 0, 0)
+		if err != nil || g == nil {
+			t.Fatalf("MeasureOnly %v: getGlyph = %v, %v", measureOnly, g, err)
+		}
+		notdef, err := fs.glyphAdvance(f, 0, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (int32(notdef)*sizeScale + 32) / 64; g.Index != 0 || g.XAdv != want {
+			t.Errorf("MeasureOnly %v: expected .notdef with advance %d, got %+v", measureOnly, want, g)
+		}
+		cells = append(cells, *g)
+	}
+	// The font's .notdef is a box, drawn and measured alike.
+	draw, measure := cells[0], cells[1]
+	if draw.X1 <= draw.X0 || draw.X1-draw.X0 != measure.X1-measure.X0 || draw.XOff != measure.XOff || draw.YOff != measure.YOff {
+		t.Errorf("Expected the same .notdef cell when drawing and measuring, got %+v and %+v", draw, measure)
+	}
+}
+
+func TestMissingGlyphAdvance(t *testing.T) {
+	for _, mode := range []int{NotdefAdvance, ZeroAdvance} {
+		mock := &MockRenderer{}
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: mock, MissingGlyphAdvance: mode})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		base, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fallback, err := fs.AddFontFromBytes("go", goregular.TTF)
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.AddFallbackFont(base, fallback)
+		fs.SetFont(base)
+		fs.SetSize(20)
+
+		// U+E000 is private use, covered by neither font.
+		plain := fs.TextBounds(0, 0, "ab", nil)
+		missing := fs.TextBounds(0, 0, "a\ue000b", nil)
+		fs.DrawText(10, 30, "a\ue000b")
+		quads := mock.Verts / vertsPerQuad
+
+		notdef, err := fs.glyphAdvance(fs.Fonts[base], 0, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch mode {
+		case NotdefAdvance:
+			if want := plain + float32(notdef)/64; math.Abs(float64(missing-want)) > 0.5 || quads != 3 {
+				t.Errorf("NotdefAdvance: expected advance %v and 3 quads, got %v and %d", want, missing, quads)
+			}
+		case ZeroAdvance:
+			if missing != plain || quads != 2 {
+				t.Errorf("ZeroAdvance: expected advance %v and 2 quads, got %v and %d", plain, missing, quads)
+			}
+		}
+		if mode != ZeroAdvance {
+			continue
+		}
+
+		// A dropped codepoint takes no letter spacing and keeps the
+		// kerning between its neighbours.
+		fs.SetSpacing(3)
+		for _, pair := range []string{"ab", "AV"} {
+			dropped := pair[:1] + "\uffff" + pair[1:]
+			if got, want := fs.TextBounds(0, 0, dropped, nil), fs.TextBounds(0, 0, pair, nil); got != want {
+				t.Errorf("Expected %q to advance %v like %q, got %v", dropped, want, pair, got)
+			}
+			_, got, _, _ := fs.TextBoxBounds(0, 0, 0, dropped)
+			if _, want, _, _ := fs.TextBoxBounds(0, 0, 0, pair); got != want {
+				t.Errorf("Expected %q to wrap %v wide like %q, got %v", dropped, want, pair, got)
+			}
+		}
+	}
+}
+
+func TestGlyphCoverage(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	if _, _, _, err := fs.GlyphCoverage(99, 'A', 20); !errors.Is(err, ErrInvalidFont) {
+		t.Errorf("Expected ErrInvalidFont, got %v", err)
+	}
+	if pix, w, h, err := fs.GlyphCoverage(fontNormal, ' ', 20); err != nil || pix != nil || w != 0 || h != 0 {
+		t.Errorf("Expected an empty bitmap for a space, got %dx%d, %v", w, h, err)
+	}
+
+	nodes := slices.Clone(fs.Atlas.nodes)
+	pix, w, h, err := fs.GlyphCoverage(fontNormal, 'A', 20)
+	if err != nil || w == 0 || h == 0 || len(pix) != w*h {
+		t.Fatalf("GlyphCoverage = %d bytes, %dx%d, %v", len(pix), w, h, err)
+	}
+	if len(fs.Fonts[fontNormal].Glyphs) != 0 || !slices.Equal(fs.Atlas.nodes, nodes) {
+		t.Errorf("Expected GlyphCoverage to leave the cache and atlas alone")
+	}
+
+	// The coverage is the atlas cell without its padding.
+	img, _, err := fs.GlyphBitmap(fontNormal, 'A', 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := fs.Params.GlyphPadding
+	if img.Bounds().Dx() != w+2*pad || img.Bounds().Dy() != h+2*pad {
+		t.Fatalf("Expected a %dx%d cell, got %v", w+2*pad, h+2*pad, img.Bounds())
+	}
+	for y := range h {
+		for x := range w {
+			if got, want := pix[y*w+x], img.AlphaAt(x+pad, y+pad).A; got != want {
+				t.Fatalf("Coverage at %d,%d is %#x, atlas has %#x", x, y, got, want)
+			}
+		}
+	}
+	// Uncovered codepoints draw nothing by default.
+	if pix, w, h, err := fs.GlyphCoverage(fontNormal, '\ue000', 20); err != nil || pix != nil || w != 0 || h != 0 {
+		t.Errorf("Expected an empty bitmap for an uncovered codepoint, got %dx%d, %v", w, h, err)
+	}
+
+	// Rasterizer failures are returned, not reported.
+	var errs []error
+	boom := errors.New("boom")
+	fs, err = New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, Rasterizer: failingRasterizer{boom}, ErrorCallback: func(err error) { errs = append(errs, err) }})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err = fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	if _, _, _, err := fs.GlyphCoverage(fontNormal, 'A', 20); !errors.Is(err, boom) || len(errs) != 0 {
+		t.Errorf("Expected boom returned and nothing reported, got %v and %v", err, errs)
+	}
+}
@@ -0,0 +1,38 @@
+package fontstash
+
+// Colors are packed into a uint32 as 0xAABBGGRR: red in the lowest byte and
+// alpha in the highest, matching the little-endian ABGR layout of the C
+// fontstash glfonsRGBA macro. State.Color and Vertex.Color both use it.
+
+// RGBA packs straight-alpha color components into a fontstash color.
+func RGBA(r, g, b, a uint8) uint32 {
+	return uint32(r) | uint32(g)<<8 | uint32(b)<<16 | uint32(a)<<24
+}
+
+// UnpackRGBA splits a fontstash color into its components, the inverse of
+// RGBA. Renderers use it to turn Vertex.Color into their own color type.
+func UnpackRGBA(c uint32) (r, g, b, a uint8) {
+	return uint8(c), uint8(c >> 8), uint8(c >> 16), uint8(c >> 24)
+}
+
+// PremultiplyRGBA packs color components into a fontstash color with red,
+// green and blue scaled by alpha.
+func PremultiplyRGBA(r, g, b, a uint8) uint32 {
+	return RGBA(mulAlpha(r, a), mulAlpha(g, a), mulAlpha(b, a), a)
+}
+
+func premultiply(c uint32) uint32 {
+	return PremultiplyRGBA(UnpackRGBA(c))
+}
+
+func mulAlpha(c, a uint8) uint8 {
+	return uint8((uint32(c)*uint32(a) + 127) / 255)
+}
+
+// vertexColor converts a state color to the form emitted in vertices.
+func (fs *FontStash) vertexColor(c uint32) uint32 {
+	if fs.Params.PremultipliedAlpha {
+		return premultiply(c)
+	}
+	return c
+}
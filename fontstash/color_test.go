@@ -0,0 +1,45 @@
+package fontstash
+
+import "testing"
+
+func TestRGBA(t *testing.T) {
+	if got := RGBA(0x11, 0x22, 0x33, 0x44); got != 0x44332211 {
+		t.Errorf("RGBA = %#08x, want 0x44332211", got)
+	}
+	for _, c := range []uint32{0, 0xffffffff, 0x44332211, 0x80ff00c0} {
+		if got := RGBA(UnpackRGBA(c)); got != c {
+			t.Errorf("RGBA(UnpackRGBA(%#08x)) = %#08x", c, got)
+		}
+	}
+	if r, g, b, a := UnpackRGBA(RGBA(1, 2, 3, 4)); r != 1 || g != 2 || b != 3 || a != 4 {
+		t.Errorf("UnpackRGBA = %d, %d, %d, %d, want 1, 2, 3, 4", r, g, b, a)
+	}
+	if got := PremultiplyRGBA(255, 128, 0, 128); got != RGBA(128, 64, 0, 128) {
+		t.Errorf("PremultiplyRGBA = %#08x, want %#08x", got, RGBA(128, 64, 0, 128))
+	}
+	if got := PremultiplyRGBA(10, 20, 30, 255); got != RGBA(10, 20, 30, 255) {
+		t.Errorf("Expected opaque colors to be unchanged, got %#08x", got)
+	}
+}
+
+func TestPremultipliedAlphaVertices(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 128, Height: 128, Renderer: rec, PremultipliedAlpha: true})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetColor(RGBA(255, 255, 255, 128))
+	fs.DrawText(0, 0, "a")
+
+	want := RGBA(128, 128, 128, 128)
+	for _, v := range rec.Vertices {
+		if v.Color != want {
+			t.Fatalf("Vertex color = %#08x, want %#08x", v.Color, want)
+		}
+	}
+}
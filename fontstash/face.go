@@ -0,0 +1,124 @@
+package fontstash
+
+import (
+	"image"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// atlasFace adapts a font handle at a fixed size to font.Face, serving
+// glyph masks straight out of the atlas.
+type atlasFace struct {
+	fs    *FontStash
+	font  int
+	isize int16
+}
+
+// NewFace returns a font.Face for the font handle at the given pixel size,
+// so fontstash's glyph cache can be used with code such as font.Drawer.
+// Glyph masks are views into the atlas texture; they stay valid until the
+// atlas is expanded or reset. The face works in device pixels, so with
+// Params.DPI above 72 its glyphs and metrics are scaled up accordingly.
+func (fs *FontStash) NewFace(fontHandle int, size float32) font.Face {
+	return &atlasFace{
+		fs:    fs,
+		font:  fontHandle,
+		isize: fs.sizeKey(size),
+	}
+}
+
+func (a *atlasFace) getFont() *Font {
+	return a.fs.getFont(a.font)
+}
+
+func (a *atlasFace) glyph(r rune) *Glyph {
+	f := a.getFont()
+	if f == nil {
+		return nil
+	}
+	g, err := a.fs.getGlyph(f, r, a.isize, 0, 0)
+	if err != nil {
+		return nil
+	}
+	return g
+}
+
+// Close implements font.Face. The face holds no resources of its own.
+func (a *atlasFace) Close() error { return nil }
+
+// Glyph implements font.Face. The returned bounds cover the glyph's whole
+// atlas cell, including padding.
+func (a *atlasFace) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	g := a.glyph(r)
+	if g == nil {
+		return image.Rectangle{}, nil, image.Point{}, 0, false
+	}
+	advance = glyphAdvance(g)
+	if g.empty() {
+		return image.Rectangle{}, a.fs.AtlasImage(), image.Point{}, advance, true
+	}
+	atlas := a.fs.AtlasPageImage(int(g.Page))
+
+	x := dot.X.Round() + int(g.XOff)
+	y := dot.Y.Round() + int(g.YOff)
+	dr = image.Rect(x, y, x+int(g.X1-g.X0), y+int(g.Y1-g.Y0))
+	return dr, atlas, image.Pt(int(g.X0), int(g.Y0)), advance, true
+}
+
+// GlyphBounds implements font.Face.
+func (a *atlasFace) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	g := a.glyph(r)
+	if g == nil {
+		return fixed.Rectangle26_6{}, 0, false
+	}
+	if !g.empty() {
+		bounds = fixed.R(int(g.XOff), int(g.YOff), int(g.XOff)+int(g.X1-g.X0), int(g.YOff)+int(g.Y1-g.Y0))
+	}
+	return bounds, glyphAdvance(g), true
+}
+
+// GlyphAdvance implements font.Face.
+func (a *atlasFace) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	g := a.glyph(r)
+	if g == nil {
+		return 0, false
+	}
+	return glyphAdvance(g), true
+}
+
+// Kern implements font.Face.
+func (a *atlasFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	f := a.getFont()
+	if f == nil {
+		return 0
+	}
+	g0 := a.fs.getGlyphIndex(f, r0)
+	g1 := a.fs.getGlyphIndex(f, r1)
+	if g0 == 0 || g1 == 0 {
+		return 0
+	}
+	return fixed.I(a.fs.getGlyphKernAdvance(f, g0, g1, a.ppem()))
+}
+
+// Metrics implements font.Face.
+func (a *atlasFace) Metrics() font.Metrics {
+	f := a.getFont()
+	if f == nil {
+		return font.Metrics{}
+	}
+	m, err := f.sfnt.Metrics(nil, fixed.Int26_6(a.ppem()*64), f.hinting)
+	if err != nil {
+		return font.Metrics{}
+	}
+	return m
+}
+
+// ppem returns the face size in device pixels.
+func (a *atlasFace) ppem() float32 {
+	return float32(a.isize) / sizeScale * a.fs.dpiScale
+}
+
+func glyphAdvance(g *Glyph) fixed.Int26_6 {
+	return fixed.Int26_6(int(g.XAdv) * 64 / sizeScale)
+}
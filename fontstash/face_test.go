@@ -0,0 +1,60 @@
+package fontstash
+
+import (
+	"image"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestFaceDrawer(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: mock, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	face := fs.NewFace(fontNormal, 24)
+	defer face.Close()
+
+	dst := image.NewAlpha(image.Rect(0, 0, 200, 50))
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.Opaque,
+		Face: face,
+		Dot:  fixed.P(10, 35),
+	}
+	d.DrawString("Hi")
+
+	var ink int
+	for _, p := range dst.Pix {
+		if p != 0 {
+			ink++
+		}
+	}
+	if ink == 0 {
+		t.Errorf("Expected the drawer to produce ink")
+	}
+
+	adv, ok := face.GlyphAdvance('H')
+	if !ok || adv <= 0 {
+		t.Errorf("Expected a positive advance for 'H', got %v %v", adv, ok)
+	}
+
+	fs.SetFont(fontNormal)
+	fs.SetSize(24)
+	want := fs.TextBounds(0, 0, "H", nil)
+	if got := float32(adv.Round()); got != want {
+		t.Errorf("Face advance %f does not match TextBounds %f", got, want)
+	}
+
+	m := face.Metrics()
+	if m.Ascent <= 0 || m.Height <= 0 {
+		t.Errorf("Expected positive metrics, got %+v", m)
+	}
+}
@@ -0,0 +1,59 @@
+package fontstash
+
+import (
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+)
+
+// faceKey identifies a cached opentype face by font handle and size in
+// tenths of a pixel.
+type faceKey struct {
+	font  int
+	isize int16
+}
+
+// rasterFace returns f's opentype face at isize, caching it on first use.
+func (fs *FontStash) rasterFace(f *Font, isize int16) (font.Face, error) {
+	key := faceKey{f.handle, isize}
+	if face, ok := fs.faces[key]; ok {
+		return face, nil
+	}
+	face, err := opentype.NewFace(f.sfnt, &opentype.FaceOptions{
+		Size:    float64(isize) / sizeScale * float64(fs.Params.Supersample),
+		DPI:     fs.Params.DPI,
+		Hinting: f.hinting,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fs.faces == nil {
+		fs.faces = make(map[faceKey]font.Face)
+	}
+	fs.faces[key] = face
+	return face, nil
+}
+
+// CloseFaces releases the opentype faces kept open for rasterizing glyph
+// misses. Each face holds buffers sized for the largest glyph it has drawn,
+// so an application cycling through many sizes can call it to return that
+// memory; faces are recreated as needed, at the cost of slower misses. The
+// faces of a font are also closed by RemoveFont and SetHinting, and every
+// face by ResetAtlas. Cached glyphs are unaffected.
+func (fs *FontStash) CloseFaces() {
+	fs.closeFaces(func(faceKey) bool { return true })
+}
+
+// closeFaces closes and forgets the cached faces whose key matches.
+func (fs *FontStash) closeFaces(match func(faceKey) bool) {
+	for key, face := range fs.faces {
+		if match(key) {
+			face.Close()
+			delete(fs.faces, key)
+		}
+	}
+}
+
+// closeFontFaces closes the cached faces of the font handle.
+func (fs *FontStash) closeFontFaces(handle int) {
+	fs.closeFaces(func(key faceKey) bool { return key.font == handle })
+}
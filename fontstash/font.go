@@ -1,7 +1,10 @@
 package fontstash
 
 import (
+	"encoding/binary"
+	"fmt"
 	"os"
+	"slices"
 
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
@@ -11,16 +14,46 @@ import (
 
 // AddFont loads a font from a file.
 func (fs *FontStash) AddFont(name, path string) (int, error) {
+	return fs.AddFontIndex(name, path, 0)
+}
+
+// AddFontIndex loads face index of a font collection (.ttc or .otc) from a
+// file, as AddFontFromBytesIndex does.
+func (fs *FontStash) AddFontIndex(name, path string, index int) (int, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return -1, err
 	}
-	return fs.AddFontFromBytes(name, data)
+	return fs.AddFontFromBytesIndex(name, data, index)
 }
 
-// AddFontFromBytes loads a font from memory.
+// AddFontFromBytes loads a font from memory. For a collection it loads the
+// first face.
 func (fs *FontStash) AddFontFromBytes(name string, data []byte) (int, error) {
-	f, err := opentype.Parse(data)
+	return fs.AddFontFromBytesIndex(name, data, 0)
+}
+
+// AddFontFromBytesIndex loads face index of the font in data, which may be
+// a single font, whose only face is 0, or a collection. It returns
+// ErrNotCollection for an index other than 0 on a single font, and
+// ErrFontIndex for an index past the end of a collection.
+func (fs *FontStash) AddFontFromBytesIndex(name string, data []byte, index int) (int, error) {
+	c, err := opentype.ParseCollection(data)
+	if err != nil {
+		return -1, err
+	}
+	// Table offsets are from the start of the file, so a face's tables are
+	// found through its own table directory.
+	directory := 0
+	if string(data[:4]) == "ttcf" {
+		if index < 0 || index >= c.NumFonts() {
+			return -1, fmt.Errorf("%w: %d of %d faces", ErrFontIndex, index, c.NumFonts())
+		}
+		directory = int(binary.BigEndian.Uint32(data[12+4*index:]))
+	} else if index != 0 {
+		return -1, fmt.Errorf("%w: face %d requested", ErrNotCollection, index)
+	}
+	f, err := c.Font(index)
 	if err != nil {
 		return -1, err
 	}
@@ -36,23 +69,57 @@ func (fs *FontStash) AddFontFromBytes(name string, data []byte) (int, error) {
 	}
 	defer face.Close()
 
-	metrics := face.Metrics()
-
 	// We use fixed point arithmetic for precision, but convert to float for storage
 	// Ascent is positive (up), Descent is positive (down) in Go font.Metrics.
 	// We convert Descent to negative coordinate to match C fontstash behavior.
-	ascent := float32(metrics.Ascent)
-	descent := -float32(metrics.Descent)
-	height := float32(metrics.Height)
+	metrics := face.Metrics()
+	asc, desc, gap := lineMetrics(metrics)
+	ascent := float32(asc)
+	descent := -float32(desc)
+	height := float32(asc + desc + gap)
 
 	fh := ascent - descent
 	if fh == 0 {
 		fh = 1
 	}
 
+	// Reuse a slot freed by RemoveFont before growing the list.
+	handle := len(fs.Fonts)
+	for i, existing := range fs.Fonts {
+		if existing == nil {
+			handle = i
+			break
+		}
+	}
+
+	// Decoration lines come from the post table where present, otherwise
+	// they are derived from the vertical metrics. Values are stored in the
+	// same normalized units as Ascender, positive above the baseline.
+	underlinePos := descent * 0.5
+	underlineThick := (ascent - descent) / 16
+	if post := f.PostTable(); post != nil && post.UnderlineThickness > 0 {
+		em := float32(1000*64) / float32(f.UnitsPerEm())
+		underlinePos = float32(post.UnderlinePosition) * em
+		underlineThick = float32(post.UnderlineThickness) * em
+	}
+	// At one pixel per design unit the scaled metrics are the design
+	// values, exact in 26.6.
+	upem := int(f.UnitsPerEm())
+	design, err := f.Metrics(nil, fixed.I(upem), font.HintingNone)
+	if err != nil {
+		return -1, err
+	}
+	designAsc, designDesc, designGap := lineMetrics(design)
+
+	strikePos := ascent * 0.3
+	if metrics.XHeight > 0 {
+		strikePos = float32(metrics.XHeight) * 0.5
+	}
+
 	fontObj := &Font{
 		Name:       name,
 		Data:       data,
+		handle:     handle,
 		sfnt:       f,
 		Ascender:   ascent / fh,
 		Descender:  descent / fh,
@@ -60,6 +127,18 @@ func (fs *FontStash) AddFontFromBytes(name string, data []byte) (int, error) {
 		Glyphs:     make([]Glyph, 0, 256),
 		Lut:        make([]int, 256),
 		Fallbacks:  make([]int, 0),
+		hinting:    font.HintingFull,
+		index:      index,
+		strikes:    parseStrikes(data, directory),
+
+		underlinePosition:  underlinePos / fh,
+		underlineThickness: underlineThick / fh,
+		strikePosition:     strikePos / fh,
+
+		unitsPerEm: upem,
+		ascent:     designAsc.Round(),
+		descent:    -designDesc.Round(),
+		lineGap:    designGap.Round(),
 	}
 
 	// Init hash lookup
@@ -67,31 +146,225 @@ func (fs *FontStash) AddFontFromBytes(name string, data []byte) (int, error) {
 		fontObj.Lut[i] = -1
 	}
 
-	fs.Fonts = append(fs.Fonts, fontObj)
-	return len(fs.Fonts) - 1, nil
+	if handle == len(fs.Fonts) {
+		fs.Fonts = append(fs.Fonts, fontObj)
+	} else {
+		fs.Fonts[handle] = fontObj
+	}
+	return handle, nil
+}
+
+// lineMetrics returns m's ascent, descent and line gap, with no negatives.
+func lineMetrics(m font.Metrics) (ascent, descent, lineGap fixed.Int26_6) {
+	lineGap = max(0, m.Height-m.Ascent-m.Descent)
+	descent = m.Descent
+	if descent < 0 {
+		// Some fonts store the hhea descender positive.
+		descent = -descent
+	}
+	return m.Ascent, descent, lineGap
+}
+
+// RemoveFont unloads a font. Its cached glyphs are dropped, including
+// glyphs other fonts rendered from it as a fallback, and it is removed from
+// every fallback list. The atlas space those glyphs used is not reclaimed
+// until the atlas is reset.
+//
+// Other handles keep their values; the removed handle becomes invalid and
+// its slot may be reused by a later AddFont.
+func (fs *FontStash) RemoveFont(handle int) error {
+	if fs.getFont(handle) == nil {
+		return ErrInvalidFont
+	}
+	fs.flush()
+	fs.Fonts[handle] = nil
+	fs.closeFontFaces(handle)
+
+	for _, f := range fs.Fonts {
+		if f != nil {
+			f.Fallbacks = slices.DeleteFunc(f.Fallbacks, func(fb int) bool { return fb == handle })
+		}
+	}
+	fs.dropRenderedGlyphs(handle)
+	return nil
+}
+
+// SetHinting chooses how a font's metrics are hinted when its glyphs are
+// rasterized and kerned. Full hinting, the default, rounds advances and
+// kerning to whole pixels, which keeps small text crisp but distorts
+// spacing at large sizes and for SDF generation. Only metrics are hinted:
+// the sfnt package does not run outline hinting instructions.
+//
+// Glyphs already rendered from the font, including as another font's
+// fallback, are dropped from the cache so they are rasterized again; their
+// atlas space is not reclaimed until a reset.
+func (fs *FontStash) SetHinting(handle int, h font.Hinting) error {
+	f := fs.getFont(handle)
+	if f == nil {
+		return ErrInvalidFont
+	}
+	if f.hinting == h {
+		return nil
+	}
+	fs.flush()
+	f.hinting = h
+	fs.closeFontFaces(handle)
+	fs.dropRenderedGlyphs(handle)
+	return nil
+}
+
+// dropRenderedGlyphs removes glyphs rasterized from the font handle from
+// every font's cache, including those it supplied as a fallback.
+func (fs *FontStash) dropRenderedGlyphs(handle int) {
+	for _, f := range fs.Fonts {
+		if f != nil {
+			f.dropGlyphs(func(g *Glyph) bool { return g.Font == handle })
+		}
+	}
+}
+
+// getFont returns the font for handle, or nil if the handle is out of
+// range or the font was removed.
+func (fs *FontStash) getFont(handle int) *Font {
+	if handle < 0 || handle >= len(fs.Fonts) {
+		return nil
+	}
+	return fs.Fonts[handle]
+}
+
+// dropGlyphs removes cached glyphs matching drop and rebuilds the lookup.
+func (f *Font) dropGlyphs(drop func(g *Glyph) bool) {
+	glyphs := f.Glyphs
+	f.Glyphs = make([]Glyph, 0, cap(glyphs))
+	for i := range f.Lut {
+		f.Lut[i] = -1
+	}
+	for i := range glyphs {
+		if drop(&glyphs[i]) {
+			continue
+		}
+		h := hashInt(int(glyphs[i].Codepoint)) & (len(f.Lut) - 1)
+		f.addGlyph(h, glyphs[i])
+	}
 }
 
 // AddFallbackFont adds a fallback font to a base font.
 func (fs *FontStash) AddFallbackFont(base, fallback int) bool {
-	if base < 0 || base >= len(fs.Fonts) || fallback < 0 || fallback >= len(fs.Fonts) {
+	if fs.getFont(base) == nil || fs.getFont(fallback) == nil {
+		return false
+	}
+	f := fs.Fonts[base]
+	f.Fallbacks = append(f.Fallbacks, fallback)
+	// Glyphs missing from the whole chain may now be covered.
+	f.dropGlyphs(func(g *Glyph) bool { return g.Index == 0 })
+	return true
+}
+
+// InsertFallbackFont adds a fallback font to a base font at the given
+// priority, where 0 is tried first. Positions past the end append.
+func (fs *FontStash) InsertFallbackFont(base, fallback, position int) bool {
+	f := fs.getFont(base)
+	if f == nil || fs.getFont(fallback) == nil {
 		return false
 	}
-	fs.Fonts[base].Fallbacks = append(fs.Fonts[base].Fallbacks, fallback)
+	position = max(0, min(position, len(f.Fallbacks)))
+	f.Fallbacks = slices.Insert(f.Fallbacks, position, fallback)
+	f.dropFallbackGlyphs()
 	return true
 }
 
+// ClearFallbacks removes every fallback font from a base font.
+func (fs *FontStash) ClearFallbacks(base int) {
+	f := fs.getFont(base)
+	if f == nil {
+		return
+	}
+	f.Fallbacks = f.Fallbacks[:0]
+	f.dropFallbackGlyphs()
+}
+
+// ResolveGlyphFont returns the handle of the font in base's fallback chain
+// that supplies codepoint, or -1 if none does and the glyph would render
+// as the base font's missing glyph box.
+func (fs *FontStash) ResolveGlyphFont(base int, codepoint rune) int {
+	f := fs.getFont(base)
+	if f == nil {
+		return -1
+	}
+	renderFont, _ := fs.resolveGlyph(f, codepoint)
+	if renderFont == nil {
+		return -1
+	}
+	return renderFont.handle
+}
+
+// HasGlyph reports whether the font or one of its fallbacks maps
+// codepoint, without rasterizing or caching anything. Codepoints for which
+// it returns false draw as the base font's missing glyph.
+func (fs *FontStash) HasGlyph(fontHandle int, codepoint rune) bool {
+	f := fs.getFont(fontHandle)
+	if f == nil {
+		return false
+	}
+	_, index := fs.resolveGlyph(f, codepoint)
+	return index != 0
+}
+
+// Kerning returns the kerning between runes a and b drawn in that order
+// at size, in pixels, as DrawText applies it. It is 0 when either rune is
+// missing, when they resolve to different fonts in the fallback chain, or
+// when the font has no kerning for the pair in its GPOS or kern table.
+func (fs *FontStash) Kerning(fontHandle int, a, b rune, size float32) float32 {
+	f := fs.getFont(fontHandle)
+	if f == nil {
+		return 0
+	}
+	fa, ia := fs.resolveGlyph(f, a)
+	fb, ib := fs.resolveGlyph(f, b)
+	if ia == 0 || ib == 0 || fa != fb {
+		return 0
+	}
+	return float32(fs.getGlyphKernAdvance(fa, ia, ib, size))
+}
+
+// resolveGlyph returns the first of f and its fallbacks to map codepoint.
+func (fs *FontStash) resolveGlyph(f *Font, codepoint rune) (*Font, int) {
+	if index := fs.getGlyphIndex(f, codepoint); index != 0 {
+		return f, index
+	}
+	for _, fb := range f.Fallbacks {
+		fallbackFont := fs.getFont(fb)
+		if fallbackFont == nil {
+			continue
+		}
+		if index := fs.getGlyphIndex(fallbackFont, codepoint); index != 0 {
+			return fallbackFont, index
+		}
+	}
+	return nil, 0
+}
+
+// dropFallbackGlyphs forgets cached glyphs whose resolution depends on the
+// fallback chain, so they are resolved again after it changes.
+func (f *Font) dropFallbackGlyphs() {
+	f.dropGlyphs(func(g *Glyph) bool { return g.Font != f.handle || g.Index == 0 })
+}
+
 // getGlyphIndex returns the glyph index for a codepoint.
 func (fs *FontStash) getGlyphIndex(f *Font, codepoint rune) int {
-	index, err := f.sfnt.GlyphIndex(nil, codepoint)
+	index, err := f.sfnt.GlyphIndex(&fs.sfntBuf, codepoint)
 	if err != nil {
 		return 0
 	}
 	return int(index)
 }
 
+// getGlyphKernAdvance returns the kerning between two glyph indices of f
+// in whole pixels. sfnt reads pair adjustments from the GPOS 'kern'
+// feature when the font has one and the legacy kern table otherwise.
 func (fs *FontStash) getGlyphKernAdvance(f *Font, glyph1, glyph2 int, size float32) int {
 	ppem := fixed.Int26_6(size * 64)
-	k, err := f.sfnt.Kern(nil, sfnt.GlyphIndex(glyph1), sfnt.GlyphIndex(glyph2), ppem, font.HintingFull)
+	k, err := f.sfnt.Kern(nil, sfnt.GlyphIndex(glyph1), sfnt.GlyphIndex(glyph2), ppem, f.hinting)
 	if err != nil {
 		return 0
 	}
@@ -0,0 +1,132 @@
+package fontstash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// collection returns a font collection holding fonts in order. Each font
+// is copied whole, with its table offsets moved to where it lands.
+func collection(fonts ...[]byte) []byte {
+	be := binary.BigEndian
+	out := []byte("ttcf")
+	out = be.AppendUint32(out, 0x00010000)
+	out = be.AppendUint32(out, uint32(len(fonts)))
+	base := len(out) + 4*len(fonts)
+	for _, data := range fonts {
+		out = be.AppendUint32(out, uint32(base))
+		base += (len(data) + 3) &^ 3
+	}
+	for _, data := range fonts {
+		start := len(out)
+		out = append(out, data...)
+		out = append(out, make([]byte, (4-len(data)%4)%4)...)
+		numTables := int(be.Uint16(data[4:]))
+		for i := range numTables {
+			rec := out[start+12+16*i:]
+			be.PutUint32(rec[8:], be.Uint32(rec[8:])+uint32(start))
+		}
+	}
+	return out
+}
+
+func TestAddFontFromBytesIndex(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	advance := func(font int) float32 {
+		fs.SetFont(font)
+		fs.SetSize(20)
+		return fs.TextBounds(0, 0, "Hello", nil)
+	}
+
+	regular, err := fs.AddFontFromBytes("regular", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	plain := fs.getGlyphIndex(fs.Fonts[regular], 'A')
+	bold, err := fs.AddFontFromBytesIndex("bold", gobold.TTF, 0)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	// Each face of a collection loads as its own font.
+	ttc := collection(goregular.TTF, gobold.TTF)
+	for index, want := range []int{regular, bold} {
+		font, err := fs.AddFontFromBytesIndex("face", ttc, index)
+		if err != nil {
+			t.Fatalf("Face %d: failed to load: %v", index, err)
+		}
+		if got, want := advance(font), advance(want); got != want {
+			t.Errorf("Face %d: expected an advance of %v, got %v", index, want, got)
+		}
+	}
+	// Bitmap strikes are read from the face's own tables.
+	strikes := collection(goregular.TTF, withStrike(plain, plain+1))
+	for index, want := range []bool{false, true} {
+		font, err := fs.AddFontFromBytesIndex("strikes", strikes, index)
+		if err != nil {
+			t.Fatalf("Face %d: failed to load: %v", index, err)
+		}
+		if (fs.Fonts[font].strikes != nil) != want {
+			t.Errorf("Face %d: expected strikes %v", index, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		data  []byte
+		index int
+		want  error
+	}{
+		{goregular.TTF, 1, ErrNotCollection},
+		{goregular.TTF, -1, ErrNotCollection},
+		{ttc, 2, ErrFontIndex},
+		{ttc, -1, ErrFontIndex},
+	} {
+		before := len(fs.Fonts)
+		if _, err := fs.AddFontFromBytesIndex("bad", tc.data, tc.index); !errors.Is(err, tc.want) {
+			t.Errorf("Index %d: expected %v, got %v", tc.index, tc.want, err)
+		}
+		if len(fs.Fonts) != before {
+			t.Errorf("Index %d: expected no font to be added", tc.index)
+		}
+	}
+
+	if _, err := fs.AddFontIndex("file", "testdata/DroidSerif-Regular.ttf", 0); err != nil {
+		t.Errorf("Expected AddFontIndex to load face 0 of a file: %v", err)
+	}
+}
+
+func TestSnapshotFaceIndex(t *testing.T) {
+	ttc := collection(goregular.TTF, gobold.TTF)
+	newStash := func(indices ...int) *FontStash {
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		for _, index := range indices {
+			if _, err := fs.AddFontFromBytesIndex("face", ttc, index); err != nil {
+				t.Fatalf("Failed to load face %d: %v", index, err)
+			}
+		}
+		return fs
+	}
+
+	var buf bytes.Buffer
+	if err := newStash(0, 1).SaveAtlas(&buf); err != nil {
+		t.Fatalf("SaveAtlas: %v", err)
+	}
+	// The faces share their data, so only the index tells them apart.
+	if err := newStash(1, 0).LoadAtlas(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrAtlasMismatch) {
+		t.Errorf("Expected swapped faces to be a mismatch, got %v", err)
+	}
+	if err := newStash(0, 1).LoadAtlas(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Errorf("Expected the same faces to load: %v", err)
+	}
+}
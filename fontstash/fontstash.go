@@ -1,12 +1,19 @@
 package fontstash
 
 import (
+	"errors"
+	"fmt"
 	"image"
 	"math"
+	"slices"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
 	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Renderer handles backend-specific operations.
@@ -16,6 +23,72 @@ type Renderer interface {
 	Draw(verts []Vertex)
 }
 
+// CheckedResizer is implemented by renderers whose texture allocation can
+// fail. When the Renderer implements it, TryResize is called instead of
+// Resize and an error leaves the atlas at its old size.
+type CheckedResizer interface {
+	TryResize(width, height int) error
+}
+
+// RawRenderer is implemented by renderers that upload vertex data directly,
+// typically to a GPU buffer. When the Renderer implements it, DrawRaw is
+// called instead of Draw with the n queued vertices as separate arrays:
+// x, y pairs in pos, u, v pairs in uv and colors in col. This skips
+// repacking them into []Vertex. The slices are reused after DrawRaw
+// returns, so they must not be retained.
+type RawRenderer interface {
+	DrawRaw(pos, uv []float32, col []uint32, n int)
+}
+
+// Batch is a run of queued vertices that all sample one texture.
+type Batch struct {
+	Texture  int // Atlas texture the vertices sample, 0 for the only one
+	Vertices []Vertex
+}
+
+// BatchRenderer is implemented by renderers that bind a texture per group
+// of vertices, for atlases split across several textures. When the
+// Renderer implements it, flush calls DrawBatch instead of Draw or DrawRaw
+// with the queued vertices grouped by texture, in drawing order. With a
+// single atlas texture there is one batch, for texture 0; see
+// Params.MaxAtlasPages for more. The slices are not reused.
+type BatchRenderer interface {
+	DrawBatch(batches []Batch)
+}
+
+// Rasterizer produces glyph bitmaps, replacing the built-in opentype
+// rasterizer when set as Params.Rasterizer. Rasterize renders codepoint
+// from font at size pixels per em, already scaled by Params.DPI. It returns
+// the coverage image, or nil for a glyph with no ink, the advance in 26.6
+// fixed point pixels, and the offset of the image's top-left corner from
+// the pen origin with y pointing down. Fallback fonts are resolved before
+// the call, so font is the one that maps codepoint, or the base font if
+// none does. Subpixel phases are not passed on.
+type Rasterizer interface {
+	Rasterize(font *Font, codepoint rune, size float64) (img *image.Alpha, advance, xoff, yoff int, err error)
+}
+
+// MultiUpdater is implemented by renderers that can upload several atlas
+// regions at once. When the Renderer implements it, flush passes the
+// individual regions written since the last flush, typically one per new
+// glyph, instead of one Update covering them all. Each rect lies inside the
+// texture; data and stride are as for Update. The rects slice is reused
+// after MultiUpdate returns. After the atlas is expanded or restored, Update
+// is called once with the whole region instead.
+type MultiUpdater interface {
+	MultiUpdate(rects []image.Rectangle, data []byte, stride int)
+}
+
+// TightUpdater is implemented by renderers that upload a tightly packed
+// rectangle, as glTexSubImage2D does without GL_UNPACK_ROW_LENGTH. When the
+// Renderer implements it, flush copies the dirty region's rows into a
+// packed buffer and calls UpdateTight instead of Update; stride is always
+// rect.Dx(). The buffer is reused after UpdateTight returns. MultiUpdater
+// takes precedence when a renderer implements both.
+type TightUpdater interface {
+	UpdateTight(rect image.Rectangle, sub []byte, stride int)
+}
+
 // Vertex represents a vertex in the quad.
 type Vertex struct {
 	X, Y, U, V float32
@@ -24,15 +97,18 @@ type Vertex struct {
 
 // Glyph represents a glyph in the atlas.
 type Glyph struct {
-	Codepoint  rune
-	Index      int // Glyph index in the font
-	Size       int16
+	Codepoint  rune  // ^Index for glyphs drawn by index, see DrawGlyphRun
+	Index      int   // Glyph index in the font
+	Size       int16 // Font size in tenths of a pixel
 	Blur       int16
-	X0, Y0     int16
-	X1, Y1     int16
-	XAdv       int16
-	XOff, YOff int16
-	Next       int // Index of next glyph in hash chain
+	Phase      int16 // Subpixel phase the glyph was rasterized at
+	X0, Y0     int32
+	X1, Y1     int32
+	XAdv       int32
+	XOff, YOff int32
+	Page       int16 // Atlas page holding the glyph, see Params.MaxAtlasPages
+	Font       int   // Handle of the font the glyph was rendered from
+	Next       int   // Index of next glyph in hash chain
 }
 
 // Font represents a loaded font.
@@ -46,17 +122,38 @@ type Font struct {
 	Lut        []int // Hash lookup
 	Fallbacks  []int
 
-	sfnt *opentype.Font
+	handle int
+	sfnt   *opentype.Font
+	index  int // Face index within a collection
+
+	// Decoration metrics, normalized like Ascender.
+	underlinePosition  float32
+	underlineThickness float32
+	strikePosition     float32
+
+	// Vertical metrics in font design units, see FontMetrics.
+	unitsPerEm, ascent, descent, lineGap int
+
+	hinting font.Hinting
+
+	strikes *strikeTables // Embedded bitmaps, nil if there are none
 }
 
 // State represents the current drawing state.
 type State struct {
-	Font    int
-	Align   int
-	Size    float32
-	Color   uint32
-	Blur    float32
-	Spacing float32
+	Font       int
+	Align      int
+	Size       float32
+	Color      uint32
+	Blur       float32
+	Spacing    float32
+	SpacingEm  float32
+	Direction  int
+	Decoration int
+	Transform  [6]float32
+	Clip       [4]float32
+	ScaleX     float32 // Horizontal scale from SetScaleXY; 0 means 1
+	ScaleY     float32 // Vertical scale from SetScaleXY; 0 means 1
 }
 
 // FontStash is the main context.
@@ -88,6 +185,27 @@ type FontStash struct {
 
 	// State stack
 	States []State
+
+	gammaLUT  [256]byte
+	whiteRect image.Rectangle // Solid white texels in the atlas
+	dpiScale  float32         // Device pixels per logical unit, DPI/72
+	ySign     float32         // 1 if y grows down the screen, -1 if up
+
+	dirtyRects []image.Rectangle // Regions within Dirty, for a MultiUpdater
+	sfntBuf    sfnt.Buffer       // Scratch for sfnt lookups
+	tightBuf   []byte            // Packed dirty region for a TightUpdater
+
+	cacheHits, cacheMisses uint64
+	draws, drawnVerts      uint64
+
+	defaults State // What ClearState resets to, see SetStateDefaults
+
+	quadFn func(Quad, uint32) // Receives quads in place of vertices, see ForEachQuad
+
+	faces map[faceKey]font.Face // Open rasterizing faces, see CloseFaces
+
+	pages   []atlasPage // Atlas pages after the first, see MaxAtlasPages
+	batches []batchSpan // Where each run of queued vertices on one page starts
 }
 
 // Params configures the FontStash.
@@ -96,6 +214,171 @@ type Params struct {
 	Renderer      Renderer
 	ErrorCallback func(error)
 	Flags         int
+
+	// Subpixel positions glyphs at fractional x offsets by caching a few
+	// horizontally shifted rasterizations of each glyph. When false, glyph
+	// origins snap to whole pixels.
+	Subpixel bool
+
+	// Gamma applies a gamma curve to glyph coverage as it is written to the
+	// atlas, which thickens text blended linearly on dark backgrounds.
+	// Values around 1.8-2.2 are recommended; 0 or 1 leaves coverage as is.
+	Gamma float32
+
+	// PremultipliedAlpha emits vertex colors with red, green and blue
+	// scaled by alpha, for renderers blending with premultiplied "over".
+	// State colors are always given with straight alpha.
+	PremultipliedAlpha bool
+
+	// GlyphPadding is the number of empty texels kept around each glyph in
+	// the atlas, on top of any blur radius. Quads are inset by one texel, so
+	// larger values leave a wider transparent border that stops neighbouring
+	// glyphs bleeding in when magnified with linear filtering. Defaults to 2.
+	GlyphPadding int
+
+	// DPI is the resolution glyphs are rasterized at, defaulting to 72 where
+	// one unit of size is one pixel. Sizes, positions and metrics stay in
+	// logical units at 72 DPI; a higher DPI only rasterizes glyphs more
+	// densely (e.g. 144 for a 2x HiDPI display) and scales the emitted quads
+	// back down, so layout is unchanged. The glyph cache is still keyed by
+	// the logical size in steps of 1/sizeScale.
+	DPI float64
+
+	// ShowMissingGlyph draws a box outline, with its own advance, for
+	// codepoints that no font in the fallback chain covers, whatever
+	// MissingGlyphAdvance says. Useful during development to spot missing
+	// fonts.
+	ShowMissingGlyph bool
+
+	// MaxVertices is the most vertices queued before they are sent to
+	// Renderer.Draw, so it is the largest batch a renderer must accept and
+	// sizes its vertex buffer. Larger values trade memory for fewer draw
+	// calls. It is rounded down to a whole number of quads (vertsPerQuad
+	// vertices each) and defaults to 1024.
+	MaxVertices int
+
+	// LineBreaker chooses where the wrapping routines may break lines. It
+	// defaults to WhitespaceBreaker; use CJKBreaker for text mixing CJK
+	// scripts or containing long URLs.
+	LineBreaker LineBreaker
+
+	// SnapBaseline rounds the baseline of horizontal text to the nearest
+	// device pixel after alignment, so fractional y positions don't shift
+	// glyphs between frames. It is skipped while a non-identity transform is
+	// set, where pixel alignment is meaningless.
+	SnapBaseline bool
+
+	// MaxAtlasSize caps the width and height ExpandAtlas and ResetAtlas may
+	// grow the atlas to. It defaults to, and cannot exceed, 32767, the
+	// largest coordinate the atlas packer can address.
+	MaxAtlasSize int
+
+	// BlurPasses is how many times the row and column box filters run over
+	// a blurred glyph. More passes approach a Gaussian shape. Defaults to 2.
+	BlurPasses int
+
+	// GaussianBlur blurs glyphs with a true separable Gaussian kernel
+	// instead of the repeated box filter, for smoother halos at the cost
+	// of speed. BlurPasses is ignored when it is set.
+	GaussianBlur bool
+
+	// Rasterizer, if set, renders glyphs instead of the built-in opentype
+	// rasterizer, e.g. to use a different hinter. Atlas packing, padding,
+	// gamma and blur still apply to its output.
+	Rasterizer Rasterizer
+
+	// Supersample rasterizes glyphs at this many times their size and
+	// box-filters them down into the atlas, at the cost of slower
+	// rasterization. Advances and atlas usage are unchanged. Values of 2 or
+	// 4 are typical; it defaults to 1, which rasterizes directly. The
+	// built-in rasterizer already computes exact area coverage, so the
+	// result differs only slightly; custom Rasterizers are not
+	// supersampled.
+	Supersample int
+
+	// AtlasNodeCapacity presizes the atlas packer's skyline, which holds
+	// one node per step in the packed glyphs' outline. Large atlases of
+	// small glyphs can need thousands; AtlasNodeCount reports the current
+	// number. Defaults to 256.
+	AtlasNodeCapacity int
+
+	// MaxAtlasPages lets the atlas grow by whole textures: when a glyph
+	// fits on none of the pages in use, a new empty page the size of the
+	// first is added, up to this many, before ErrAtlasFull is reported.
+	// Glyphs record their page, and flush hands each page's quads to
+	// DrawBatch as a separate batch. Pages beyond the first need a
+	// renderer implementing PageRenderer; otherwise, and by default, there
+	// is one page. ExpandAtlas and RepackAtlas fail once a second page is
+	// in use; ResetAtlas drops the extra pages.
+	MaxAtlasPages int
+
+	// GlyphResolvedCallback, if set, is called on each glyph cache miss
+	// with the handle of the font that supplies codepoint and whether it
+	// is a fallback of the font drawn with, or -1 if no font in the chain
+	// covers it. Cached glyphs don't call it again, so it sees each
+	// codepoint once per size and blur, making it cheap enough to log
+	// fallback use across a whole corpus.
+	GlyphResolvedCallback func(codepoint rune, fontHandle int, fromFallback bool)
+
+	// MeasureOnly makes a FontStash for text measurement alone, e.g. for
+	// server-side layout. No atlas texture is allocated and no glyph is
+	// rasterized: measuring reads advances and outline bounds from the
+	// font, so TextBounds and friends return the same results as when
+	// drawing. Draw calls lay text out but emit nothing, the atlas
+	// accessors return ErrMeasureOnly or nothing, and Renderer and
+	// Rasterizer are ignored. See NewMeasurer.
+	MeasureOnly bool
+
+	// Normalize converts text to Unicode NFC before DrawText, TextBounds
+	// and the functions built on them lay it out, so a base letter and a
+	// combining mark, such as "e" and U+0301, draw as the precomposed
+	// glyph where the font has one rather than as two overlapping glyphs.
+	// It is a stopgap short of mark positioning: scripts that need real
+	// shaping are not helped, and DrawTextFunc's rune indices count the
+	// normalized text. Caret and hit-testing functions see the text as
+	// given.
+	Normalize bool
+
+	// NoWhiteRect leaves out the small solid white region normally packed
+	// into the atlas, keeping the atlas to glyphs alone. Without it
+	// DrawRect, decoration lines and DebugDrawAtlasBounds draw nothing and
+	// WhiteRectUV returns 0, 0.
+	NoWhiteRect bool
+
+	// WhiteRectSize is the width and height in texels of the solid white
+	// region. WhiteRectUV points at its center, so a larger region keeps
+	// solid fills clear of the glyphs around it under heavy texture
+	// filtering, such as mipmapping. Defaults to 2.
+	WhiteRectSize int
+
+	// BlurBounds grows the boxes from TextBounds, TextLineBounds and
+	// TextBoundsBytes by the current blur on every side, so a background
+	// or selection box drawn from them clears a glow's falloff. Unblurred
+	// text measures the same either way.
+	BlurBounds bool
+
+	// MissingGlyphAdvance chooses how codepoints that no font in the
+	// fallback chain covers are laid out: ZeroAdvance, the default, caches
+	// them as empty glyphs with no advance, so they draw nothing, as if
+	// they weren't in the text; NotdefAdvance draws the base font's
+	// .notdef glyph with its advance.
+	MissingGlyphAdvance int
+
+	// AtlasChangedCallback, if set, is called after ExpandAtlas or
+	// ResetAtlas changes the atlas, with its new size and why it changed,
+	// so backends can reallocate anything sized to the texture, such as
+	// mip chains or framebuffers. It follows Renderer.Resize, which only
+	// says the texture must be recreated: after AtlasExpanded the glyphs
+	// are kept at their texels, after AtlasReset they are gone.
+	AtlasChangedCallback func(width, height int, reason AtlasChangeReason)
+
+	// RendererPreservesOnResize tells ExpandAtlas that the renderer keeps
+	// the texture's pixels across Resize, e.g. by copying them into the
+	// larger texture, so nothing is uploaded after an expand: the newly
+	// exposed region holds no glyphs until some are packed there. By
+	// default the renderer is assumed to lose them and every row holding
+	// glyphs is uploaded again.
+	RendererPreservesOnResize bool
 }
 
 // Alignment flags
@@ -109,7 +392,36 @@ const (
 	AlignBaseline = 1 << 6
 )
 
-// Zero coordinate system
+// Text decorations
+const (
+	DecorationUnderline     = 1 << 0
+	DecorationStrikethrough = 1 << 1
+)
+
+// Text directions
+const (
+	DirLTR = iota // Left to right, the default
+	DirTTB        // Top to bottom, for vertical CJK text
+)
+
+// Missing glyph advances, see Params.MissingGlyphAdvance
+const (
+	ZeroAdvance   = iota // Draw nothing and don't advance, the default
+	NotdefAdvance        // Draw .notdef with its advance
+)
+
+// AtlasChangeReason says why the atlas changed, see
+// Params.AtlasChangedCallback.
+type AtlasChangeReason int
+
+// Atlas change reasons
+const (
+	AtlasExpanded AtlasChangeReason = iota // ExpandAtlas grew it, keeping the glyphs
+	AtlasReset                             // ResetAtlas cleared it, maybe resizing it
+)
+
+// Zero coordinate system. With neither flag set, y grows down from the
+// top-left corner as with ZeroTopLeft.
 const (
 	ZeroTopLeft    = 1
 	ZeroBottomLeft = 2
@@ -121,14 +433,28 @@ const (
 	maxBlur        = 20
 	minFontSize    = 2
 	blurPadding    = 2
-	initAtlasNodes = 256
+	initAtlasNodes = 256 // Default Params.AtlasNodeCapacity
 	initFonts      = 4
-	maxVertices    = 1024
-	whiteRectSize  = 2
+	maxVertices    = 1024 // Default Params.MaxVertices
+	maxDirtyRects  = 64   // Beyond this, MultiUpdate gets one coalesced rect
+	whiteRectSize  = 2    // Default Params.WhiteRectSize
 	sizeScale      = 10.0
 	vertsPerQuad   = 6
+	subpixelPhases = 3
 )
 
+// identityTransform is the 2x3 affine matrix that leaves points unchanged.
+var identityTransform = [6]float32{1, 0, 0, 1, 0, 0}
+
+// vertexTransform returns the state's transform, or nil if it is the
+// identity, so a draw checks it once rather than per vertex.
+func (s *State) vertexTransform() *[6]float32 {
+	if s.Transform == identityTransform {
+		return nil
+	}
+	return &s.Transform
+}
+
 // Common errors
 type Error string
 
@@ -139,6 +465,14 @@ const (
 	ErrScratchFull     = Error("scratch memory full")
 	ErrStatesOverflow  = Error("state stack overflow")
 	ErrStatesUnderflow = Error("state stack underflow")
+	ErrAtlasMismatch   = Error("atlas snapshot does not match")
+	ErrInvalidFont     = Error("invalid font handle")
+	ErrOutOfBounds     = Error("region outside atlas")
+	ErrMeasureOnly     = Error("no atlas in measure-only mode")
+	ErrRasterize       = Error("cannot rasterize glyph")
+	ErrFontSize        = Error("font size too large")
+	ErrNotCollection   = Error("font is not a collection")
+	ErrFontIndex       = Error("font index out of range")
 )
 
 // New creates a new FontStash context.
@@ -149,7 +483,43 @@ func New(params Params) (*FontStash, error) {
 	if params.Height == 0 {
 		params.Height = 512
 	}
+	if params.GlyphPadding <= 0 {
+		params.GlyphPadding = blurPadding
+	}
+	if params.DPI <= 0 {
+		params.DPI = 72
+	}
+	if params.MaxAtlasSize <= 0 || params.MaxAtlasSize > math.MaxInt16 {
+		params.MaxAtlasSize = math.MaxInt16
+	}
+	if params.AtlasNodeCapacity <= 0 {
+		params.AtlasNodeCapacity = initAtlasNodes
+	}
+	if params.MaxAtlasPages <= 0 {
+		params.MaxAtlasPages = 1
+	}
+	if params.Supersample <= 0 {
+		params.Supersample = 1
+	}
+	if params.BlurPasses <= 0 {
+		params.BlurPasses = 2
+	}
+	if params.WhiteRectSize <= 0 {
+		params.WhiteRectSize = whiteRectSize
+	}
+	if params.MaxVertices <= 0 {
+		params.MaxVertices = maxVertices
+	}
+	params.MaxVertices = max(vertsPerQuad, params.MaxVertices-params.MaxVertices%vertsPerQuad)
+	if params.MeasureOnly {
+		params.Renderer = nil
+	}
+	return newFontStash(params), nil
+}
 
+// newFontStash creates a FontStash from params that New has already
+// defaulted.
+func newFontStash(params Params) *FontStash {
 	fs := &FontStash{
 		Params:  params,
 		Width:   params.Width,
@@ -157,19 +527,88 @@ func New(params Params) (*FontStash, error) {
 		Itw:     1.0 / float32(params.Width),
 		Ith:     1.0 / float32(params.Height),
 		Dirty:   image.Rectangle{Min: image.Point{params.Width, params.Height}, Max: image.Point{0, 0}},
-		Atlas:   newAtlas(params.Width, params.Height, initAtlasNodes), // FONS_INIT_ATLAS_NODES
+		Atlas:   newAtlas(params.Width, params.Height, params.AtlasNodeCapacity),
 		Fonts:   make([]*Font, 0, initFonts),
-		TexData: make([]byte, params.Width*params.Height),
+		TexData: newTexData(params.MeasureOnly, params.Width, params.Height),
 		States:  make([]State, 0, maxStates),
+		Verts:   make([]float32, 0, params.MaxVertices*2),
+		TCoords: make([]float32, 0, params.MaxVertices*2),
+		Colors:  make([]uint32, 0, params.MaxVertices),
+
+		dpiScale: float32(params.DPI / 72),
+		ySign:    1,
+		defaults: builtinState,
+	}
+
+	if params.Flags&ZeroBottomLeft != 0 && params.Flags&ZeroTopLeft == 0 {
+		fs.ySign = -1
+	}
+
+	for i := range fs.gammaLUT {
+		fs.gammaLUT[i] = byte(i)
+	}
+	if params.Gamma > 0 && params.Gamma != 1 {
+		inv := 1.0 / float64(params.Gamma)
+		for i := range fs.gammaLUT {
+			fs.gammaLUT[i] = byte(math.Round(255 * math.Pow(float64(i)/255, inv)))
+		}
 	}
 
 	// Add white rect at 0,0 for debug drawing.
-	fs.addWhiteRect(whiteRectSize, whiteRectSize)
+	fs.addWhiteRect(fs.Params.WhiteRectSize, fs.Params.WhiteRectSize)
 
 	fs.PushState()
 	fs.ClearState()
 
-	return fs, nil
+	return fs
+}
+
+// NewMeasurer creates a FontStash that only measures text, as New does
+// with Params.MeasureOnly set. No Renderer is needed.
+func NewMeasurer(params Params) (*FontStash, error) {
+	params.MeasureOnly = true
+	return New(params)
+}
+
+// Clone returns a FontStash with the same Params, atlas size, fonts,
+// fallbacks and state stack as fs, but its own empty atlas, glyph caches
+// and faces. The font data and parsed fonts are shared rather than copied.
+// They are only ever read, which x/image/font/sfnt allows from several
+// goroutines at once, so fs and each clone can lay out and draw text on
+// their own goroutines without a lock. Fonts added, removed or given
+// fallbacks later are not seen by the other. The clone keeps fs's
+// Renderer; set Params.Renderer on the clone before it draws to give it a
+// texture of its own.
+func (fs *FontStash) Clone() *FontStash {
+	params := fs.Params
+	params.Width, params.Height = fs.Width, fs.Height
+	c := newFontStash(params)
+
+	c.Fonts = make([]*Font, len(fs.Fonts), cap(fs.Fonts))
+	for i, f := range fs.Fonts {
+		if f == nil {
+			continue
+		}
+		nf := *f
+		nf.Glyphs = make([]Glyph, 0, 256)
+		nf.Lut = make([]int, len(f.Lut))
+		for j := range nf.Lut {
+			nf.Lut[j] = -1
+		}
+		nf.Fallbacks = slices.Clone(f.Fallbacks)
+		c.Fonts[i] = &nf
+	}
+	c.defaults = fs.defaults
+	c.States = append(c.States[:0], fs.States...)
+	return c
+}
+
+// newTexData allocates the atlas texture, or nothing when measuring only.
+func newTexData(measureOnly bool, width, height int) []byte {
+	if measureOnly {
+		return nil
+	}
+	return make([]byte, width*height)
 }
 
 func (fs *FontStash) PushState() {
@@ -184,7 +623,7 @@ func (fs *FontStash) PushState() {
 		top := fs.States[len(fs.States)-1]
 		fs.States = append(fs.States, top)
 	} else {
-		fs.States = append(fs.States, State{}) // Should be cleared anyway
+		fs.States = append(fs.States, fs.defaultState())
 	}
 }
 
@@ -199,24 +638,82 @@ func (fs *FontStash) PopState() {
 }
 
 func (fs *FontStash) ClearState() {
-	state := &fs.States[len(fs.States)-1]
-	state.Size = 12.0
-	state.Color = 0xffffffff
-	state.Font = 0
-	state.Blur = 0
-	state.Spacing = 0
-	state.Align = AlignLeft | AlignBaseline
+	*fs.getState() = fs.defaultState()
+}
+
+// SaveState returns a copy of the current state. Unlike PushState it
+// leaves the stack alone, so a library can change the font or size and put
+// them back with RestoreState without disturbing its caller's push/pop
+// depth.
+func (fs *FontStash) SaveState() State {
+	return *fs.getState()
+}
+
+// RestoreState replaces the current state with a copy of s, typically one
+// returned by SaveState.
+func (fs *FontStash) RestoreState(s State) {
+	*fs.getState() = s
+}
+
+// builtinState is the default state until SetStateDefaults replaces it.
+var builtinState = State{
+	Size:      12.0,
+	Color:     0xffffffff,
+	Align:     AlignLeft | AlignBaseline,
+	Direction: DirLTR,
+	Transform: identityTransform,
+}
+
+// SetStateDefaults replaces the values ClearState resets a state to, and
+// clears the current state to them, so calling it straight after New sets
+// the initial state too. A zero Transform is taken as the identity.
+func (fs *FontStash) SetStateDefaults(defaults State) {
+	if defaults.Transform == ([6]float32{}) {
+		defaults.Transform = identityTransform
+	}
+	fs.defaults = defaults
+	fs.ClearState()
+}
+
+// defaultState returns the values ClearState resets a state to.
+func (fs *FontStash) defaultState() State {
+	return fs.defaults
+}
+
+// Reset returns the context to a clean slate between frames: the state
+// stack is popped back to a single cleared state and queued vertices are
+// discarded without being drawn. Atlas changes not yet uploaded are sent
+// to the renderer, leaving the dirty rect empty. Unlike ResetAtlas, fonts
+// and cached glyphs are kept, so nothing is rasterized again.
+func (fs *FontStash) Reset() {
+	fs.dropVerts()
+	fs.flush()
+
+	fs.States = fs.States[:0]
+	fs.PushState()
+	fs.ClearState()
 }
 
+// getState returns the top of the state stack. A stack emptied by hand
+// through States gets a fresh default state rather than panicking.
 func (fs *FontStash) getState() *State {
+	if len(fs.States) == 0 {
+		fs.States = append(fs.States, fs.defaultState())
+	}
 	return &fs.States[len(fs.States)-1]
 }
 
 func (fs *FontStash) addWhiteRect(w, h int) {
+	if fs.Params.MeasureOnly || fs.Params.NoWhiteRect {
+		fs.whiteRect = image.Rectangle{}
+		return
+	}
 	gx, gy, ok := fs.Atlas.addRect(w, h)
 	if !ok {
+		fs.whiteRect = image.Rectangle{}
 		return
 	}
+	fs.whiteRect = image.Rect(gx, gy, gx+w, gy+h)
 
 	// Rasterize
 	dst := fs.TexData
@@ -227,19 +724,47 @@ func (fs *FontStash) addWhiteRect(w, h int) {
 		}
 	}
 
-	// Update dirty rect
-	if gx < fs.Dirty.Min.X {
-		fs.Dirty.Min.X = gx
-	}
-	if gy < fs.Dirty.Min.Y {
-		fs.Dirty.Min.Y = gy
-	}
-	if gx+w > fs.Dirty.Max.X {
-		fs.Dirty.Max.X = gx + w
+	fs.markDirty(gx, gy, w, h)
+}
+
+// glyphAdvance returns the advance of glyph index in f at size, hinted as
+// the font's glyphs are.
+func (fs *FontStash) glyphAdvance(f *Font, index int, size float64) (fixed.Int26_6, error) {
+	// Scale the same way opentype.NewFace does so advances match
+	// rasterized glyphs exactly.
+	ppem := fixed.Int26_6(0.5 + size*fs.Params.DPI*64/72)
+	return f.sfnt.GlyphAdvance(&fs.sfntBuf, sfnt.GlyphIndex(index), ppem, f.hinting)
+}
+
+// markDirty extends the dirty rect to cover the atlas region at x, y of
+// size w by h, so the next flush uploads it.
+func (fs *FontStash) markDirty(x, y, w, h int) {
+	fs.Dirty.Min.X = min(fs.Dirty.Min.X, x)
+	fs.Dirty.Min.Y = min(fs.Dirty.Min.Y, y)
+	fs.Dirty.Max.X = max(fs.Dirty.Max.X, x+w)
+	fs.Dirty.Max.Y = max(fs.Dirty.Max.Y, y+h)
+
+	if _, ok := fs.Params.Renderer.(MultiUpdater); ok {
+		if len(fs.dirtyRects) < maxDirtyRects {
+			fs.dirtyRects = append(fs.dirtyRects, image.Rect(x, y, x+w, y+h))
+		} else {
+			fs.dirtyRects = append(fs.dirtyRects[:0], fs.Dirty)
+		}
 	}
-	if gy+h > fs.Dirty.Max.Y {
-		fs.Dirty.Max.Y = gy + h
+}
+
+// WhiteRectUV returns texture coordinates at the center of the solid white
+// region of the atlas. Drawing a quad with every corner at these coordinates
+// fills it with the vertex color, so solid shapes can share the text shader.
+// The region keeps its texels across ExpandAtlas, but the coordinates change
+// with the atlas size, so query them again after it changes. They are 0, 0
+// when the atlas has no white rect, see Params.NoWhiteRect.
+func (fs *FontStash) WhiteRectUV() (u, v float32) {
+	if fs.whiteRect.Empty() {
+		return 0, 0
 	}
+	c := fs.whiteRect.Min.Add(fs.whiteRect.Max)
+	return float32(c.X) * 0.5 * fs.Itw, float32(c.Y) * 0.5 * fs.Ith
 }
 
 func hashInt(a int) int {
@@ -252,173 +777,490 @@ func hashInt(a int) int {
 	return a
 }
 
-func (fs *FontStash) getGlyph(f *Font, codepoint rune, isize, iblur int16) (*Glyph, error) {
+func (fs *FontStash) getGlyph(f *Font, codepoint rune, isize, iblur, phase int16) (*Glyph, error) {
 	if isize < minFontSize {
 		return nil, nil
 	}
-	if iblur > maxBlur {
-		iblur = maxBlur
+	iblur = max(0, min(iblur, maxBlur))
+	h, g := f.findGlyph(codepoint, isize, iblur, phase)
+	if g != nil {
+		fs.cacheHits++
+		return g, nil
 	}
-	pad := int(iblur) + blurPadding
+	fs.cacheMisses++
 
-	h := hashInt(int(codepoint)) & (len(f.Lut) - 1)
-	i := f.Lut[h]
-	for i != -1 {
-		g := &f.Glyphs[i]
-		if g.Codepoint == codepoint && g.Size == isize && g.Blur == iblur {
-			return g, nil
+	// Create glyph
+	renderFont, gIndex := fs.resolveGlyph(f, codepoint)
+	if cb := fs.Params.GlyphResolvedCallback; cb != nil {
+		if gIndex == 0 {
+			cb(codepoint, -1, false)
+		} else {
+			cb(codepoint, renderFont.handle, renderFont != f)
 		}
-		i = g.Next
 	}
-
-	// Create glyph
-	gIndex := fs.getGlyphIndex(f, codepoint)
-	renderFont := f
 	if gIndex == 0 {
-		for _, fb := range f.Fallbacks {
-			fallbackFont := fs.Fonts[fb]
-			fallbackIndex := fs.getGlyphIndex(fallbackFont, codepoint)
-			if fallbackIndex != 0 {
-				gIndex = fallbackIndex
-				renderFont = fallbackFont
-				break
-			}
+		renderFont = f
+	}
+
+	var (
+		dr      image.Rectangle
+		mask    image.Image
+		maskp   image.Point
+		advance fixed.Int26_6
+	)
+	// Codepoints given no advance are cached empty and draw nothing.
+	if !fs.zeroAdvance(codepoint, gIndex) {
+		var warn, err error
+		dr, mask, maskp, advance, warn, err = fs.rasterizeGlyph(f, renderFont, codepoint, gIndex, isize, phase)
+		if warn != nil {
+			fs.reportError(warn)
+		}
+		if err != nil {
+			return nil, fs.reportError(err)
 		}
 	}
 
+	return fs.packGlyph(f, h, Glyph{
+		Codepoint: codepoint,
+		Size:      isize,
+		Blur:      iblur,
+		Phase:     phase,
+		Index:     gIndex,
+		XAdv:      (int32(advance)*sizeScale + 32) / 64,
+		Font:      renderFont.handle,
+	}, dr, mask, maskp)
+}
+
+// rasterizeGlyph renders glyph gIndex of renderFont; getGlyph reports errors.
+func (fs *FontStash) rasterizeGlyph(f, renderFont *Font, codepoint rune, gIndex int, isize, phase int16) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, warn, err error) {
 	size := float64(isize) / sizeScale
+	dot := fixed.Point26_6{X: fixed.Int26_6(int(phase) * 64 / subpixelPhases)}
+	if gIndex == 0 && fs.Params.ShowMissingGlyph {
+		dr, mask, advance = fs.missingGlyph(f, size)
+	} else if sdr, smask, sadv, ok := fs.strikeGlyph(renderFont, gIndex, size); ok {
+		dr, mask, advance = sdr, smask, fixed.I(sadv)
+	} else if fs.Params.MeasureOnly {
+		dr, advance, err = fs.outlineBounds(renderFont, gIndex, size, dot)
+		if err != nil {
+			warn = wrapRasterError(codepoint, err)
+		}
+		if err != nil && gIndex != 0 {
+			dr, _, advance = fs.missingGlyph(f, size)
+		} else if err != nil {
+			dr = image.Rectangle{}
+		}
+	} else if fs.Params.Rasterizer != nil {
+		img, adv, xoff, yoff, err := fs.Params.Rasterizer.Rasterize(renderFont, codepoint, size*float64(fs.dpiScale))
+		if err != nil {
+			return dr, nil, maskp, 0, nil, wrapRasterError(codepoint, err)
+		}
+		advance = fixed.Int26_6(adv)
+		if img != nil {
+			b := img.Bounds()
+			dr, mask, maskp = image.Rect(xoff, yoff, xoff+b.Dx(), yoff+b.Dy()), img, b.Min
+		}
+	} else {
+		// Get glyph metrics and bitmap
+		n := fs.Params.Supersample
+		face, err := fs.rasterFace(renderFont, isize)
+		if err != nil {
+			return dr, nil, maskp, 0, nil, wrapRasterError(codepoint, err)
+		}
 
-	// Get glyph metrics and bitmap
-	face, err := opentype.NewFace(renderFont.sfnt, &opentype.FaceOptions{
-		Size:    size,
-		DPI:     72,
-		Hinting: font.HintingFull,
-	})
-	if err != nil {
-		return nil, err
+		// For codepoints no font covers, Glyph returns the .notdef glyph
+		// but reports !ok, as GlyphBounds does. Those only get here with
+		// NotdefAdvance, or as glyph 0 drawn by index, so accept it when
+		// it loaded; ok is then only false when the outline can't be
+		// loaded, and advance is zero with it.
+		var ok bool
+		dr, mask, maskp, advance, ok = face.Glyph(dot.Mul(fixed.I(n)), codepoint)
+		ok = ok || gIndex == 0 && mask != nil
+		if ok && n > 1 {
+			// Advances come from the requested size so layout doesn't
+			// depend on the supersampling.
+			dr, mask = downsample(dr, mask, maskp, n)
+			maskp = image.Point{}
+			if advance, err = fs.glyphAdvance(renderFont, gIndex, size); err != nil {
+				return dr, nil, maskp, 0, nil, wrapRasterError(codepoint, err)
+			}
+		}
+		if !ok && gIndex != 0 {
+			// The font maps the codepoint but its outline can't be
+			// decoded, e.g. a color bitmap emoji, so draw tofu rather
+			// than nothing.
+			warn = wrapRasterError(codepoint, errUndecodable)
+			dr, mask, advance = fs.missingGlyph(f, size)
+			maskp = image.Point{}
+		} else if !ok {
+			// Not even .notdef could be loaded, so cache an empty glyph
+			// that takes no space.
+			dr, mask, advance = image.Rectangle{}, nil, 0
+		}
 	}
-	defer face.Close()
+	return dr, mask, maskp, advance, warn, nil
+}
 
-	// Bounds check skipped
-	_, advance, ok := face.GlyphBounds(codepoint)
-	if !ok {
-		// Continue but with empty bounds/image?
-		// Fallthrough
+// outlineBounds measures glyph index of f from its outline, not rasterizing it.
+func (fs *FontStash) outlineBounds(f *Font, index int, size float64, dot fixed.Point26_6) (image.Rectangle, fixed.Int26_6, error) {
+	n := fs.Params.Supersample
+	_, dr, advance, err := fs.loadOutline(f, sfnt.GlyphIndex(index), size*float64(fs.dpiScale)*float64(n), dot.Mul(fixed.I(n)))
+	if err == nil && n > 1 {
+		dr = shrinkRect(dr, n)
+		advance, err = fs.glyphAdvance(f, index, size)
 	}
+	return dr, advance, err
+}
 
-	dr, mask, _, _, ok := face.Glyph(fixed.P(0, 0), codepoint)
-	if !ok {
-		// Fallthrough
+// strikeGlyph returns glyph index of f from a bitmap strike for size, if any.
+func (fs *FontStash) strikeGlyph(f *Font, index int, size float64) (image.Rectangle, *image.Alpha, int, bool) {
+	if f.strikes == nil || fs.Params.Rasterizer != nil {
+		return image.Rectangle{}, nil, 0, false
+	}
+	return f.strikes.glyph(index, size*float64(fs.dpiScale))
+}
+
+// strikeAdvance returns the advance of the glyph strikeGlyph would return,
+// without decoding its bitmap.
+func (fs *FontStash) strikeAdvance(f *Font, index int, size float64) (int, bool) {
+	if f.strikes == nil || fs.Params.Rasterizer != nil {
+		return 0, false
+	}
+	return f.strikes.advance(index, size*float64(fs.dpiScale))
+}
+
+// zeroAdvance reports whether an uncovered codepoint is cached with no advance.
+func (fs *FontStash) zeroAdvance(codepoint rune, gIndex int) bool {
+	return gIndex == 0 && codepoint >= 0 && !fs.Params.ShowMissingGlyph && fs.Params.MissingGlyphAdvance == ZeroAdvance
+}
+
+// dropped reports whether layout skips g, an uncovered codepoint left empty.
+func (fs *FontStash) dropped(g *Glyph) bool {
+	return fs.zeroAdvance(g.Codepoint, g.Index)
+}
+
+// errUndecodable is reported for mapped glyphs whose outline can't be
+// loaded; opentype.Face.Glyph doesn't say why.
+var errUndecodable = errors.New("outline could not be decoded")
+
+// rasterError wraps err as wrapRasterError does and reports it to the
+// error callback.
+func (fs *FontStash) rasterError(codepoint rune, err error) error {
+	return fs.reportError(wrapRasterError(codepoint, err))
+}
+
+// wrapRasterError wraps err as an ErrRasterize for codepoint, which is
+// negative for glyphs drawn by index.
+func wrapRasterError(codepoint rune, err error) error {
+	if codepoint < 0 {
+		return fmt.Errorf("%w: glyph index %d: %w", ErrRasterize, ^codepoint, err)
+	}
+	return fmt.Errorf("%w: %U: %w", ErrRasterize, codepoint, err)
+}
+
+// reportError passes err to the error callback, if any, and returns it.
+func (fs *FontStash) reportError(err error) error {
+	if fs.Params.ErrorCallback != nil {
+		fs.Params.ErrorCallback(err)
+	}
+	return err
+}
+
+// findGlyph looks up a cached glyph, returning its hash bucket and the
+// glyph, or nil if it isn't cached.
+func (f *Font) findGlyph(codepoint rune, isize, iblur, phase int16) (int, *Glyph) {
+	h := hashInt(int(codepoint)) & (len(f.Lut) - 1)
+	for i := f.Lut[h]; i != -1; i = f.Glyphs[i].Next {
+		g := &f.Glyphs[i]
+		if g.Codepoint == codepoint && g.Size == isize && g.Blur == iblur && g.Phase == phase {
+			return h, g
+		}
+	}
+	return h, nil
+}
+
+// packGlyph copies mask into a new atlas cell and caches glyph under bucket h.
+func (fs *FontStash) packGlyph(f *Font, h int, glyph Glyph, dr image.Rectangle, mask image.Image, maskp image.Point) (*Glyph, error) {
+	if dr.Empty() {
+		// Whitespace and control characters have an advance but no
+		// coverage, so cache them without reserving atlas space.
+		return f.addGlyph(h, glyph), nil
 	}
 
+	iblur := glyph.Blur
+	pad := int(iblur) + fs.Params.GlyphPadding
 	gw := dr.Dx() + pad*2
 	gh := dr.Dy() + pad*2
 
+	if fs.Params.MeasureOnly {
+		// There is no atlas; the cell only records the glyph's extent so
+		// quads and bounds come out as they would when drawing.
+		glyph.X1, glyph.Y1 = int32(gw), int32(gh)
+		glyph.XOff = int32(dr.Min.X - pad)
+		glyph.YOff = int32(dr.Min.Y - pad)
+		return f.addGlyph(h, glyph), nil
+	}
+
 	// Find free spot
-	gx, gy, ok := fs.Atlas.addRect(gw, gh)
+	page, gx, gy, ok := fs.addCell(gw, gh)
 	if !ok {
-		// Atlas full
-		if fs.Params.ErrorCallback != nil {
-			fs.Params.ErrorCallback(ErrAtlasFull)
-		}
-		// Try again? The C code calls handler and tries again.
-		// User might resize in callback.
-		gx, gy, ok = fs.Atlas.addRect(gw, gh)
-		if !ok {
-			return nil, ErrAtlasFull
-		}
+		return nil, ErrAtlasFull
 	}
 
-	// Init glyph
-	glyph := Glyph{
-		Codepoint: codepoint,
-		Size:      isize,
-		Blur:      iblur,
-		Index:     gIndex,
-		X0:        int16(gx),
-		Y0:        int16(gy),
-		X1:        int16(gx + gw),
-		Y1:        int16(gy + gh),
-		XAdv:      int16(int32(advance) * sizeScale / 64),
-		XOff:      int16(dr.Min.X - pad),
-		YOff:      int16(dr.Min.Y - pad),
-	}
+	glyph.Page = int16(page)
+	glyph.X0 = int32(gx)
+	glyph.Y0 = int32(gy)
+	glyph.X1 = int32(gx + gw)
+	glyph.Y1 = int32(gy + gh)
+	glyph.XOff = int32(dr.Min.X - pad)
+	glyph.YOff = int32(dr.Min.Y - pad)
 
 	// Copy bitmap to texture
-	dst := fs.TexData
+	dst := fs.pageTex(page)
 	width := fs.Params.Width
 
-	if mask != nil {
-		b := mask.Bounds()
-		for y := 0; y < b.Dy(); y++ {
-			for x := 0; x < b.Dx(); x++ {
-				_, _, _, a := mask.At(x+b.Min.X, y+b.Min.Y).RGBA()
-				val := uint8(a >> 8)
-
-				targetX := gx + pad + x
-				targetY := gy + pad + y
-				if targetX < width && targetY < fs.Params.Height {
-					dst[targetY*width+targetX] = val
-				}
+	// Clear the whole padded cell so the blur only ever sees this glyph.
+	for y := gy; y < gy+gh; y++ {
+		clear(dst[y*width+gx : y*width+gx+gw])
+	}
+
+	// Copy just the ink box; the mask may extend beyond dr and must not
+	// spill into the padding or a neighbouring cell. The box is clipped
+	// to the texture on every side so odd glyph metrics can't write
+	// outside it.
+	ink := image.Rect(0, 0, dr.Dx(), dr.Dy()).Add(image.Pt(gx+pad, gy+pad))
+	target := ink.Intersect(image.Rect(0, 0, width, fs.Params.Height))
+	if mask != nil && !target.Empty() {
+		copyMask(dst[target.Min.Y*width+target.Min.X:], width, mask, maskp.Add(target.Min.Sub(ink.Min)), target.Dx(), target.Dy(), &fs.gammaLUT)
+	}
+
+	// Blur if needed. The blur region is exactly the packed cell, so
+	// the padding absorbs the spread without touching other glyphs.
+	if iblur > 0 {
+		fs.blur(dst, gx, gy, gw, gh, width, int(iblur))
+	}
+
+	fs.markPageDirty(page, gx, gy, gw, gh)
+
+	return f.addGlyph(h, glyph), nil
+}
+
+// CacheCounters returns how many glyph lookups were served from the cache
+// and how many had to be rasterized since the last ResetCacheCounters. A
+// high miss rate in steady state suggests sizes or blurs are churning.
+func (fs *FontStash) CacheCounters() (hits, misses uint64) {
+	return fs.cacheHits, fs.cacheMisses
+}
+
+// ResetCacheCounters zeroes the counters returned by CacheCounters.
+func (fs *FontStash) ResetCacheCounters() {
+	fs.cacheHits, fs.cacheMisses = 0, 0
+}
+
+// DrawStats returns how many batches were sent to the renderer and how
+// many vertices they held since the last ResetDrawStats, for frame
+// telemetry. Each run of vertices sampling one atlas page is a batch, so
+// with several pages one DrawBatch call can count as more than one.
+func (fs *FontStash) DrawStats() (draws, verts uint64) {
+	return fs.draws, fs.drawnVerts
+}
+
+// ResetDrawStats zeroes the counters returned by DrawStats.
+func (fs *FontStash) ResetDrawStats() {
+	fs.draws, fs.drawnVerts = 0, 0
+}
+
+// missingGlyph draws the box standing in for a codepoint no font covers.
+func (fs *FontStash) missingGlyph(f *Font, size float64) (image.Rectangle, image.Image, fixed.Int26_6) {
+	em := size * float64(fs.dpiScale)
+	w, gap := missingGlyphWidth(em)
+	h := max(3, int(math.Round(em*float64(f.Ascender))))
+	t := max(1, int(math.Round(em/16)))
+
+	mask := image.NewAlpha(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < t || y < t || x >= w-t || y >= h-t {
+				mask.Pix[y*mask.Stride+x] = 0xff
 			}
 		}
 	}
+	return image.Rect(gap, -h, gap+w, 0), mask, fixed.I(w + 2*gap)
+}
 
-	// Blur if needed
-	if iblur > 0 {
-		fs.blur(gx, gy, gw, gh, width, int(iblur))
+// missingGlyphWidth returns the width of the missing glyph box and the gap
+// on either side of it for an em of the given device pixel size.
+func missingGlyphWidth(em float64) (w, gap int) {
+	return max(3, int(math.Round(em*0.5))), int(math.Round(em * 0.1))
+}
+
+// measureGlyph returns the glyph for codepoint, from its metrics where it can.
+func (fs *FontStash) measureGlyph(f *Font, codepoint rune, isize, iblur, phase int16) (Glyph, bool) {
+	if isize < minFontSize {
+		return Glyph{}, false
+	}
+	iblur = max(0, min(iblur, maxBlur))
+	if _, g := f.findGlyph(codepoint, isize, iblur, phase); g != nil {
+		return *g, true
+	}
+
+	if fs.Params.Rasterizer != nil && !fs.Params.MeasureOnly {
+		// Only the rasterizer knows its advances.
+		g, err := fs.getGlyph(f, codepoint, isize, iblur, phase)
+		if err != nil || g == nil {
+			return Glyph{}, false
+		}
+		return *g, true
 	}
 
-	// Update dirty rect
-	if gx < fs.Dirty.Min.X {
-		fs.Dirty.Min.X = gx
+	renderFont, gIndex := fs.resolveGlyph(f, codepoint)
+	if gIndex == 0 {
+		renderFont = f
 	}
-	if gy < fs.Dirty.Min.Y {
-		fs.Dirty.Min.Y = gy
+	size := float64(isize) / sizeScale
+	var advance fixed.Int26_6
+	if fs.zeroAdvance(codepoint, gIndex) {
+		// empty
+	} else if gIndex == 0 && fs.Params.ShowMissingGlyph {
+		w, gap := missingGlyphWidth(size * float64(fs.dpiScale))
+		advance = fixed.I(w + 2*gap)
+	} else if adv, ok := fs.strikeAdvance(renderFont, gIndex, size); ok {
+		advance = fixed.I(adv)
+	} else {
+		adv, err := fs.glyphAdvance(renderFont, gIndex, size)
+		if err != nil {
+			return Glyph{}, false
+		}
+		advance = adv
 	}
-	if gx+gw > fs.Dirty.Max.X {
-		fs.Dirty.Max.X = gx + gw
+	return Glyph{
+		Codepoint: codepoint,
+		Size:      isize,
+		Blur:      iblur,
+		Phase:     phase,
+		Index:     gIndex,
+		XAdv:      (int32(advance)*sizeScale + 32) / 64,
+		Font:      renderFont.handle,
+		Next:      -1,
+	}, true
+}
+
+// copyMask copies w by h of mask at maskp into dst through lut, if not nil.
+func copyMask(dst []byte, stride int, mask image.Image, maskp image.Point, w, h int, lut *[256]byte) {
+	if alpha, ok := mask.(*image.Alpha); ok {
+		// The rasterizers produce alpha masks, whose rows can be read
+		// directly rather than through At.
+		for y := range h {
+			src := alpha.Pix[alpha.PixOffset(maskp.X, maskp.Y+y):][:w]
+			row := dst[y*stride:][:w]
+			if lut == nil {
+				copy(row, src)
+				continue
+			}
+			for x, a := range src {
+				row[x] = lut[a]
+			}
+		}
+		return
 	}
-	if gy+gh > fs.Dirty.Max.Y {
-		fs.Dirty.Max.Y = gy + gh
+	for y := range h {
+		for x := range w {
+			_, _, _, a := mask.At(maskp.X+x, maskp.Y+y).RGBA()
+			if lut != nil {
+				a = uint32(lut[uint8(a>>8)]) << 8
+			}
+			dst[y*stride+x] = uint8(a >> 8)
+		}
 	}
+}
 
-	// Add to cache
-	f.Glyphs = append(f.Glyphs, glyph)
-	f.Glyphs[len(f.Glyphs)-1].Next = f.Lut[h]
+// addGlyph appends g to the font's cache under hash bucket h.
+func (f *Font) addGlyph(h int, g Glyph) *Glyph {
+	g.Next = f.Lut[h]
+	f.Glyphs = append(f.Glyphs, g)
 	f.Lut[h] = len(f.Glyphs) - 1
+	return &f.Glyphs[len(f.Glyphs)-1]
+}
 
-	return &f.Glyphs[len(f.Glyphs)-1], nil
+// empty reports whether the glyph has no bitmap in the atlas.
+func (g *Glyph) empty() bool {
+	return g.X0 == g.X1 || g.Y0 == g.Y1
 }
 
-func (fs *FontStash) blur(x, y, w, h, stride, blur int) {
+func (fs *FontStash) blur(dst []byte, x, y, w, h, stride, blur int) {
 	if blur < 1 {
 		return
 	}
 
+	if fs.Params.GaussianBlur {
+		fs.gaussianBlur(dst, x, y, w, h, stride, blur)
+		return
+	}
+
 	sigma := float32(blur) * 0.57735 // 1 / sqrt(3)
 	alpha := int((1 << 16) * (1.0 - math.Exp(float64(-2.3/(sigma+1.0)))))
 
-	fs.blurRows(x, y, w, h, stride, alpha)
-	fs.blurCols(x, y, w, h, stride, alpha)
-	fs.blurRows(x, y, w, h, stride, alpha)
-	fs.blurCols(x, y, w, h, stride, alpha)
+	for range fs.Params.BlurPasses {
+		fs.blurRows(dst, x, y, w, h, stride, alpha)
+		fs.blurCols(dst, x, y, w, h, stride, alpha)
+	}
 }
 
-func (fs *FontStash) blurRows(x, y, w, h, stride, alpha int) {
-	dst := fs.TexData
+// gaussianBlur blurs the region with a Gaussian kernel of the given radius.
+func (fs *FontStash) gaussianBlur(dst []byte, x, y, w, h, stride, radius int) {
+	sigma := float64(radius) * 0.5
+	kernel := make([]float32, 2*radius+1)
+	var sum float32
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = float32(math.Exp(-d * d / (2 * sigma * sigma)))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	line := make([]float32, max(w, h))
+	convolve := func(start, step, n int) {
+		for i := range n {
+			line[i] = float32(dst[start+i*step])
+		}
+		for i := range n {
+			var v float32
+			for k, weight := range kernel {
+				if j := i + k - radius; j >= 0 && j < n {
+					v += line[j] * weight
+				}
+			}
+			dst[start+i*step] = uint8(min(255, v+0.5))
+		}
+	}
+	for r := range h {
+		convolve((y+r)*stride+x, 1, w)
+	}
+	for c := range w {
+		convolve(y*stride+x+c, stride, h)
+	}
+}
+
+func (fs *FontStash) blurRows(dst []byte, x, y, w, h, stride, alpha int) {
+	// Both edge texels are zeroed, so narrower rows have nothing to blur
+	// and an empty one would index before its start.
+	if w < 2 {
+		return
+	}
+	// Outputs round to nearest so repeated passes don't drain coverage.
 	for r := 0; r < h; r++ {
 		offset := (y+r)*stride + x
 		z := 0
 		for c := 1; c < w; c++ {
 			z += (alpha * ((int(dst[offset+c]) << 7) - z)) >> 16
-			dst[offset+c] = uint8(z >> 7)
+			dst[offset+c] = uint8((z + 64) >> 7)
 		}
 		dst[offset+w-1] = 0
 		z = 0
 		for c := w - 2; c >= 0; c-- {
 			z += (alpha * ((int(dst[offset+c]) << 7) - z)) >> 16
-			dst[offset+c] = uint8(z >> 7)
+			dst[offset+c] = uint8((z + 64) >> 7)
 		}
 		dst[offset] = 0
 	}
@@ -426,7 +1268,38 @@ func (fs *FontStash) blurRows(x, y, w, h, stride, alpha int) {
 
 // SetSize sets the font size in the current state.
 func (fs *FontStash) SetSize(size float32) {
-	fs.getState().Size = size
+	fs.getState().Size = fs.clampSize(size)
+}
+
+// MaxFontSize is the largest font size glyphs can be cached at, as sizes
+// are kept in tenths of a pixel in an int16. Larger sizes are clamped to
+// it and reported to the error callback as ErrFontSize.
+const MaxFontSize = math.MaxInt16 / sizeScale
+
+// clampSize limits size to MaxFontSize, reporting ErrFontSize if it was
+// larger.
+func (fs *FontStash) clampSize(size float32) float32 {
+	if size <= MaxFontSize {
+		return size
+	}
+	if fs.Params.ErrorCallback != nil {
+		fs.Params.ErrorCallback(fmt.Errorf("%w: %v clamped to %v", ErrFontSize, size, float32(MaxFontSize)))
+	}
+	return MaxFontSize
+}
+
+// sizeKey returns the glyph cache size for size in tenths of a pixel.
+// Negative sizes become 0, which draws nothing, rather than overflowing.
+func (fs *FontStash) sizeKey(size float32) int16 {
+	return int16(max(fs.clampSize(size), 0) * sizeScale)
+}
+
+// SetSizePt sets the font size in points for a display of dpi dots per
+// inch, storing the equivalent pixel size as SetSize would: 12pt at 96 DPI
+// is 16px. The dpi only converts the size; glyphs are still rasterized at
+// Params.DPI.
+func (fs *FontStash) SetSizePt(points, dpi float32) {
+	fs.SetSize(points * dpi / 72)
 }
 
 // SetColor sets the color in the current state.
@@ -439,8 +1312,63 @@ func (fs *FontStash) SetSpacing(spacing float32) {
 	fs.getState().Spacing = spacing
 }
 
-// SetBlur sets the blur amount in the current state.
-func (fs *FontStash) SetBlur(blur float32) {
+// SetSpacingEm sets additional character spacing as a fraction of the font
+// size, so tracking stays proportional when the size changes. It adds to
+// the absolute spacing from SetSpacing rather than replacing it.
+func (fs *FontStash) SetSpacingEm(fraction float32) {
+	fs.getState().SpacingEm = fraction
+}
+
+// spacing returns the total character spacing of the state in pixels.
+func (s *State) spacing() float32 {
+	return s.Spacing + s.SpacingEm*s.Size*s.scaleX()
+}
+
+// SetScaleXY stretches text by sx horizontally and sy vertically on top of
+// the font size, for condensed or expanded text without a separate face.
+// Glyphs are rasterized at size*sy and their quads, advances and kerning
+// are scaled by sx/sy, so a non-uniform scale samples the atlas stretched.
+// Vertical metrics, decorations and TextBounds follow the scaled size,
+// which is limited to MaxFontSize. The default of 1, 1 draws text
+// unscaled. Scales that aren't positive are ignored.
+func (fs *FontStash) SetScaleXY(sx, sy float32) {
+	if sx <= 0 || sy <= 0 {
+		return
+	}
+	state := fs.getState()
+	state.ScaleX, state.ScaleY = sx, sy
+}
+
+// scaleX returns the state's horizontal scale, treating the zero value of
+// a State literal, or any other scale that isn't positive, as 1.
+func (s *State) scaleX() float32 {
+	if s.ScaleX <= 0 {
+		return 1
+	}
+	return s.ScaleX
+}
+
+// scaleY is scaleX for the vertical scale.
+func (s *State) scaleY() float32 {
+	if s.ScaleY <= 0 {
+		return 1
+	}
+	return s.ScaleY
+}
+
+// layoutSize returns the size glyphs are rasterized and laid out at: the
+// font size scaled vertically, clamped as SetSize clamps sizes.
+func (s *State) layoutSize() float32 {
+	return min(s.Size*s.scaleY(), MaxFontSize)
+}
+
+// stretch returns how much wider than at layoutSize glyphs are drawn.
+func (s *State) stretch() float32 {
+	return s.scaleX() / s.scaleY()
+}
+
+// SetBlur sets the blur amount in the current state.
+func (fs *FontStash) SetBlur(blur float32) {
 	fs.getState().Blur = blur
 }
 
@@ -449,47 +1377,135 @@ func (fs *FontStash) SetAlign(align int) {
 	fs.getState().Align = align
 }
 
-// SetFont sets the current font.
-func (fs *FontStash) SetFont(font int) {
+// SetDirection sets the text direction in the current state.
+//
+// In DirTTB mode glyphs are stacked downwards: x is the center of the
+// column, y is the top of the first glyph's em box, alignment and kerning
+// are ignored, and DrawText returns the final pen y instead of x. Latin
+// glyphs are drawn upright rather than rotated.
+func (fs *FontStash) SetDirection(dir int) {
+	fs.getState().Direction = dir
+}
+
+// SetDecoration sets the text decoration flags in the current state.
+// DrawText draws each decoration as a solid line in the current color
+// spanning the text's advance, in the same batch as the glyphs. Decorations
+// are not drawn for DirTTB text.
+func (fs *FontStash) SetDecoration(flags int) {
+	fs.getState().Decoration = flags
+}
+
+// SetTransform sets the 2x3 affine matrix {a, b, c, d, e, f} applied to
+// every emitted vertex position, mapping x, y to a*x + c*y + e and
+// b*x + d*y + f. Layout still happens in untransformed coordinates and the
+// glyph bitmaps stay axis-aligned in the atlas, so rotated or skewed text
+// samples them through the transformed quad corners.
+func (fs *FontStash) SetTransform(m [6]float32) {
+	fs.getState().Transform = m
+}
+
+// SetClipRect restricts drawn glyphs and rects to the rectangle spanned by
+// x0, y0 and x1, y1, before any transform. Quads outside it are dropped and
+// quads crossing its edges are trimmed along with their texture
+// coordinates, so no GPU scissor is needed. An empty rect disables clipping.
+func (fs *FontStash) SetClipRect(x0, y0, x1, y1 float32) {
+	fs.getState().Clip = [4]float32{min(x0, x1), min(y0, y1), max(x0, x1), max(y0, y1)}
+}
+
+// SetFont sets the current font. It returns false and reports
+// ErrInvalidFont through the error callback, leaving the state unchanged,
+// if font is not a loaded font handle.
+func (fs *FontStash) SetFont(font int) bool {
+	if fs.getFont(font) == nil {
+		if fs.Params.ErrorCallback != nil {
+			fs.Params.ErrorCallback(ErrInvalidFont)
+		}
+		return false
+	}
 	fs.getState().Font = font
+	return true
 }
 
 func (fs *FontStash) getVertAlign(f *Font, align int, isize int16) float32 {
-	size := float32(isize) / sizeScale
-	if fs.Params.Flags&ZeroTopLeft != 0 {
-		if align&AlignTop != 0 {
-			return f.Ascender * size
-		} else if align&AlignMiddle != 0 {
-			return (f.Ascender + f.Descender) / 2.0 * size
-		} else if align&AlignBaseline != 0 {
-			return 0.0
-		} else if align&AlignBottom != 0 {
-			return f.Descender * size
-		}
-	} else {
-		if align&AlignTop != 0 {
-			return -f.Ascender * size
-		} else if align&AlignMiddle != 0 {
-			return -(f.Ascender + f.Descender) / 2.0 * size
-		} else if align&AlignBaseline != 0 {
-			return 0.0
-		} else if align&AlignBottom != 0 {
-			return -f.Descender * size
-		}
+	size := float32(isize) / sizeScale * fs.ySign
+	if align&AlignTop != 0 {
+		return f.Ascender * size
+	} else if align&AlignMiddle != 0 {
+		return (f.Ascender + f.Descender) / 2.0 * size
+	} else if align&AlignBaseline != 0 {
+		return 0.0
+	} else if align&AlignBottom != 0 {
+		return f.Descender * size
 	}
 	return 0.0
 }
 
+// snapBaseline rounds y to a device pixel if Params.SnapBaseline applies.
+func (fs *FontStash) snapBaseline(state *State, y float32) float32 {
+	if !fs.Params.SnapBaseline || state.Transform != identityTransform {
+		return y
+	}
+	return float32(math.Round(float64(y*fs.dpiScale))) / fs.dpiScale
+}
+
 type Quad struct {
 	X0, Y0, S0, T0 float32
 	X1, Y1, S1, T1 float32
 }
 
-func (fs *FontStash) getQuad(f *Font, prevGlyphIndex int, glyph *Glyph, scale, spacing float32, x, y *float32, q *Quad) {
-	if prevGlyphIndex != -1 {
-		adv := fs.getGlyphKernAdvance(f, prevGlyphIndex, glyph.Index, float32(glyph.Size)/sizeScale)
-		*x += float32(int(float32(adv)*scale + spacing + 0.5))
+// placeGlyph fills q for glyph at the pen and returns its atlas page.
+func (fs *FontStash) placeGlyph(f *Font, glyph *Glyph, scale, x, y float32, q *Quad) int {
+	if fs.Params.Subpixel && !glyph.empty() {
+		dx := float64(x * fs.dpiScale)
+		pix := math.Floor(dx)
+		phase := int16(math.Floor((dx-pix)*subpixelPhases + 0.5))
+		if phase == subpixelPhases {
+			pix++
+			phase = 0
+		}
+		x = float32(pix) / fs.dpiScale
+		if phase != glyph.Phase {
+			var g *Glyph
+			var err error
+			if glyph.Codepoint < 0 {
+				g, err = fs.getGlyphByIndex(f, int(^glyph.Codepoint), glyph.Size, glyph.Blur, phase)
+			} else {
+				g, err = fs.getGlyph(f, glyph.Codepoint, glyph.Size, glyph.Blur, phase)
+			}
+			if err == nil && g != nil {
+				glyph = g
+			}
+		}
+	}
+	fs.setQuad(glyph, scale, x, y, q)
+	return int(glyph.Page)
+}
+
+// kernPen moves the pen x by the kerning between prev and glyph plus spacing.
+func (fs *FontStash) kernPen(prev, glyph *Glyph, scale, spacing, x float32) float32 {
+	// Glyph indices are only meaningful within the font that supplied
+	// them, so kerning is skipped across a fallback boundary.
+	adv := 0
+	if prev.Font == glyph.Font {
+		adv = fs.getGlyphKernAdvance(fs.Fonts[glyph.Font], prev.Index, glyph.Index, float32(glyph.Size)/sizeScale)
 	}
+	return x + float32(adv)*scale + spacing
+}
+
+// advancePen moves the pen x past glyph, its advance widened by scale.
+func (fs *FontStash) advancePen(glyph *Glyph, scale, x float32) float32 {
+	// Glyph metrics are in device pixels; the pen moves in logical units.
+	return x + float32(glyph.XAdv)*scale/sizeScale/fs.dpiScale
+}
+
+// setQuad fills q for glyph drawn at the pen x, y and widened by scale.
+func (fs *FontStash) setQuad(glyph *Glyph, scale, x, y float32, q *Quad) {
+	if glyph.empty() {
+		// Nothing to draw, only the pen advances.
+		*q = Quad{}
+		return
+	}
+	ds := fs.dpiScale
 
 	xoff := float32(glyph.XOff + 1)
 	yoff := float32(glyph.YOff + 1)
@@ -498,173 +1514,619 @@ func (fs *FontStash) getQuad(f *Font, prevGlyphIndex int, glyph *Glyph, scale, s
 	x1 := float32(glyph.X1 - 1)
 	y1 := float32(glyph.Y1 - 1)
 
-	var rx, ry float32
-	if fs.Params.Flags&ZeroTopLeft != 0 {
-		rx = float32(int(*x + xoff))
-		ry = float32(int(*y + yoff))
+	// Glyph offsets are y-down; Y0 is always the glyph's top edge.
+	rx := float32(math.Floor(float64(x*ds+xoff*scale)+0.5)) / ds
+	ry := float32(int(y*ds+fs.ySign*yoff)) / ds
 
-		q.X0 = rx
-		q.Y0 = ry
-		q.X1 = rx + x1 - x0
-		q.Y1 = ry + y1 - y0
+	q.X0 = rx
+	q.Y0 = ry
+	q.X1 = rx + (x1-x0)*scale/ds
+	q.Y1 = ry + fs.ySign*(y1-y0)/ds
 
-		q.S0 = x0 * fs.Itw
-		q.T0 = y0 * fs.Ith
-		q.S1 = x1 * fs.Itw
-		q.T1 = y1 * fs.Ith
-	} else {
-		rx = float32(int(*x + xoff))
-		ry = float32(int(*y - yoff))
+	q.S0 = x0 * fs.Itw
+	q.T0 = y0 * fs.Ith
+	q.S1 = x1 * fs.Itw
+	q.T1 = y1 * fs.Ith
+}
 
-		q.X0 = rx
-		q.Y0 = ry
-		q.X1 = rx + x1 - x0
-		q.Y1 = ry - y1 + y0
+// clipEmpty reports whether clip disables clipping.
+func clipEmpty(clip *[4]float32) bool {
+	return clip[0] >= clip[2] || clip[1] >= clip[3]
+}
 
-		q.S0 = x0 * fs.Itw
-		q.T0 = y0 * fs.Ith
-		q.S1 = x1 * fs.Itw
-		q.T1 = y1 * fs.Ith
+// clipQuad trims q to clip, moving its texture coordinates in proportion.
+// It reports false if nothing of q remains.
+func clipQuad(q *Quad, clip *[4]float32) bool {
+	var ok bool
+	if q.X0, q.X1, q.S0, q.S1, ok = clipSpan(q.X0, q.X1, q.S0, q.S1, clip[0], clip[2]); !ok {
+		return false
 	}
+	q.Y0, q.Y1, q.T0, q.T1, ok = clipSpan(q.Y0, q.Y1, q.T0, q.T1, clip[1], clip[3])
+	return ok
+}
 
-	*x += float32(int(float32(glyph.XAdv)/sizeScale + 0.5))
+// clipSpan clips the edge a0..a1, which may run in either direction, to
+// lo..hi and interpolates the texture coordinates s0..s1 to match.
+func clipSpan(a0, a1, s0, s1, lo, hi float32) (float32, float32, float32, float32, bool) {
+	if a0 > a1 {
+		a1, a0, s1, s0, ok := clipSpan(a1, a0, s1, s0, lo, hi)
+		return a0, a1, s0, s1, ok
+	}
+	if a1 <= lo || a0 >= hi {
+		return a0, a1, s0, s1, false
+	}
+	d := a1 - a0
+	if a0 < lo {
+		s0 += (s1 - s0) * (lo - a0) / d
+		a0 = lo
+	}
+	if a1 > hi {
+		s1 -= (s1 - s0) * (a1 - hi) / (a1 - a0)
+		a1 = hi
+	}
+	return a0, a1, s0, s1, true
 }
 
-func (fs *FontStash) vertex(x, y, s, t float32, c uint32) {
+// getQuadVertical fills q for glyph in top-to-bottom layout and moves the pen.
+func (fs *FontStash) getQuadVertical(f *Font, glyph *Glyph, scale float32, x, y *float32, q *Quad) {
+	// The vhea/vmtx tables aren't exposed by sfnt, so use the em box.
+	size := float32(glyph.Size) / sizeScale
+	penX := *x - float32(glyph.XAdv)*scale/sizeScale/fs.dpiScale*0.5
+	fs.setQuad(glyph, scale, penX, *y+fs.ySign*f.Ascender*size, q)
+	*y += fs.ySign * size
+}
+
+// vertex queues one vertex, transformed by xf unless it is nil.
+func (fs *FontStash) vertex(xf *[6]float32, x, y, s, t float32, c uint32) {
+	if xf != nil {
+		x, y = transformPoint(xf, x, y)
+	}
 	fs.Verts = append(fs.Verts, x, y)
 	fs.TCoords = append(fs.TCoords, s, t)
 	fs.Colors = append(fs.Colors, c)
 	fs.NVerts++
 }
 
+// DrawRect queues a solid rectangle in the given color, sampling the atlas's
+// white rect so it can share the text shader and vertex stream. The quad is
+// sent with the next DrawText or Flush, keeping backgrounds and text in one
+// batch. Coordinates follow the same Zero* convention as text. It draws
+// nothing if the atlas has no white rect.
+func (fs *FontStash) DrawRect(x0, y0, x1, y1 float32, color uint32) {
+	if fs.whiteRect.Empty() {
+		return
+	}
+	u, v := fs.WhiteRectUV()
+	color = fs.vertexColor(color)
+
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	// Order the corners like glyph quads: y0 is the top edge.
+	if (fs.ySign > 0) == (y0 > y1) {
+		y0, y1 = y1, y0
+	}
+
+	q := Quad{X0: x0, Y0: y0, S0: u, T0: v, X1: x1, Y1: y1, S1: u, T1: v}
+	state := fs.getState()
+	fs.emitQuad(state, state.vertexTransform(), &q, 0, color)
+}
+
+// DebugDrawAtlasBounds queues outlines of the regions the skyline packer
+// has filled, in the given color, to visualize the atlas layout when
+// diagnosing ErrAtlasFull. Each skyline node outlines the column from the
+// top of the atlas down to its height. One unit is one texel with the
+// atlas origin at 0, 0, so set a transform to place and scale the overlay;
+// with ZeroBottomLeft it is drawn upside down. The quads are sent with the
+// next DrawText or Flush.
+func (fs *FontStash) DebugDrawAtlasBounds(color uint32) {
+	for _, n := range fs.Atlas.nodes {
+		if n.y == 0 {
+			continue
+		}
+		x0, x1, y1 := float32(n.x), float32(n.x+n.width), float32(n.y)
+		fs.DrawRect(x0, 0, x0+1, y1, color)
+		fs.DrawRect(x1-1, 0, x1, y1, color)
+		fs.DrawRect(x0, y1-1, x1, y1, color)
+	}
+}
+
+// Flush uploads pending atlas changes and draws any queued vertices.
+func (fs *FontStash) Flush() {
+	fs.flush()
+}
+
+// runeIter decodes runes from a string, a byte slice or a rune slice, so
+// the entry points share one layout loop without converting.
+type runeIter struct {
+	s      string
+	b      []byte
+	r      []rune
+	normal bool // Already passed through normalized
+}
+
+func (it *runeIter) next() (rune, bool) {
+	if len(it.b) > 0 {
+		r, n := utf8.DecodeRune(it.b)
+		it.b = it.b[n:]
+		return r, true
+	}
+	if len(it.s) > 0 {
+		r, n := utf8.DecodeRuneInString(it.s)
+		it.s = it.s[n:]
+		return r, true
+	}
+	if len(it.r) > 0 {
+		r := it.r[0]
+		it.r = it.r[1:]
+		return r, true
+	}
+	return 0, false
+}
+
+// layout moves the pen through text one rune at a time, placing each glyph
+// as DrawText does, so drawing and every measurement share one loop.
+type layout struct {
+	fs             *FontStash
+	f              *Font
+	text           runeIter
+	isize, iblur   int16
+	scale, spacing float32
+	vertical       bool
+	x, y           float32 // Pen position
+	prev           *Glyph
+
+	// Set by next for the rune just laid out.
+	codepoint rune
+	glyph     *Glyph  // nil if the rune has no glyph and the pen didn't move
+	penX      float32 // Pen x the glyph was placed at, after kerning
+	q         Quad
+	page      int // Atlas page q samples
+}
+
+// newLayout starts laying out text in f with the state's settings at x, y.
+func (fs *FontStash) newLayout(state *State, f *Font, text runeIter, x, y float32, vertical bool) layout {
+	return layout{
+		fs:       fs,
+		f:        f,
+		text:     text,
+		isize:    fs.sizeKey(state.layoutSize()),
+		iblur:    int16(state.Blur),
+		scale:    state.stretch(),
+		spacing:  state.spacing(),
+		vertical: vertical,
+		x:        x,
+		y:        y,
+	}
+}
+
+// next lays out the next rune, reporting false at the end of the text.
+func (l *layout) next() bool {
+	codepoint, ok := l.text.next()
+	if !ok {
+		return false
+	}
+	l.codepoint = codepoint
+	l.penX = l.x
+	glyph, err := l.fs.getGlyph(l.f, codepoint, l.isize, l.iblur, 0)
+	if err != nil || glyph != nil && l.fs.dropped(glyph) {
+		// Kern the next glyph against the last one that was laid out.
+		l.glyph = nil
+		return true
+	}
+	if glyph != nil {
+		if l.vertical {
+			l.fs.getQuadVertical(l.f, glyph, l.scale, &l.x, &l.y, &l.q)
+			l.page = int(glyph.Page)
+		} else {
+			if l.prev != nil {
+				l.x = l.fs.kernPen(l.prev, glyph, l.scale, l.spacing, l.x)
+			}
+			l.penX = l.x
+			l.page = l.fs.placeGlyph(l.f, glyph, l.scale, l.x, l.y, &l.q)
+			l.x = l.fs.advancePen(glyph, l.scale, l.x)
+		}
+	}
+	l.glyph = glyph
+	l.prev = glyph
+	return true
+}
+
+// inked reports whether the rune just laid out left a quad to draw.
+func (l *layout) inked() bool {
+	return l.glyph != nil && !l.glyph.empty()
+}
+
+// normalized returns text converted to NFC if Params.Normalize is set,
+// marked so that passing it through again is free.
+func (fs *FontStash) normalized(text runeIter) runeIter {
+	if !fs.Params.Normalize || text.normal {
+		return text
+	}
+	if len(text.b) > 0 {
+		if !norm.NFC.IsNormal(text.b) {
+			text.b = norm.NFC.Bytes(text.b)
+		}
+	} else if !norm.NFC.IsNormalString(text.s) {
+		text.s = norm.NFC.String(text.s)
+	}
+	text.normal = true
+	return text
+}
+
 // DrawText draws the text at the specified position.
 func (fs *FontStash) DrawText(x, y float32, str string) float32 {
+	return fs.drawText(x, y, runeIter{s: str}, nil, nil)
+}
+
+// DrawTextBounds is like DrawText but also fills bounds with what
+// TextBounds would return for the same text, gathered while drawing rather
+// than by laying the text out a second time.
+func (fs *FontStash) DrawTextBounds(x, y float32, str string, bounds *[4]float32) float32 {
+	return fs.drawText(x, y, runeIter{s: str}, nil, bounds)
+}
+
+// DrawTextBytes is like DrawText but reads UTF-8 text from b, avoiding a
+// string conversion in render loops that build text in a buffer.
+func (fs *FontStash) DrawTextBytes(x, y float32, b []byte) float32 {
+	return fs.drawText(x, y, runeIter{b: b}, nil, nil)
+}
+
+// DrawTextFunc is like DrawText but colors each glyph with the value
+// returned by colorFn, which is called once per rune in order with the
+// rune's index. This suits syntax highlighting, where spans differ only in
+// color and splitting the string would re-measure alignment per span.
+func (fs *FontStash) DrawTextFunc(x, y float32, str string, colorFn func(runeIndex int, r rune) uint32) float32 {
+	return fs.drawText(x, y, runeIter{s: str}, colorFn, nil)
+}
+
+// ForEachQuad lays out str as DrawText does and calls fn with each glyph
+// quad and its vertex color instead of queuing vertices, for renderers
+// that build their own vertex format, such as instanced quads. Decoration
+// lines are passed as quads sampling the white rect. Quads are clipped to
+// the clip rect but not transformed. Glyphs are cached and the atlas
+// changes uploaded as in DrawText, so the texture is ready when it
+// returns. It returns the pen position after the text.
+func (fs *FontStash) ForEachQuad(x, y float32, str string, fn func(q Quad, color uint32)) float32 {
+	fs.quadFn = fn
+	defer func() { fs.quadFn = nil }()
+	return fs.drawText(x, y, runeIter{s: str}, nil, nil)
+}
+
+// drawText draws text, filling bounds as TextBounds would if it is not nil.
+func (fs *FontStash) drawText(x, y float32, text runeIter, colorFn func(int, rune) uint32, bounds *[4]float32) float32 {
+	text = fs.normalized(text)
 	state := fs.getState()
-	if state.Font < 0 || state.Font >= len(fs.Fonts) {
+	f := fs.getFont(state.Font)
+	if f == nil {
 		return x
 	}
-	f := fs.Fonts[state.Font]
 	if f.Data == nil {
 		return x
 	}
 
-	isize := int16(state.Size * sizeScale)
-	iblur := int16(state.Blur)
+	vertical := state.Direction == DirTTB
+	measured := false
+	if !vertical {
+		x, measured = fs.alignX(state, x, y, text, bounds)
+		y = fs.snapBaseline(state, y+fs.getVertAlign(f, state.Align, fs.sizeKey(state.layoutSize())))
+	}
+	// Unless aligning measured the text already, the glyphs are placed
+	// exactly as TextBounds places them, so their quads give its bounds.
+	track := bounds != nil && !measured
+	e := newLineExtent(x, y)
+
+	color := fs.vertexColor(state.Color)
+	xf := state.vertexTransform()
+	startX := x
+
+	l := fs.newLayout(state, f, text, x, y, vertical)
+	for i := 0; l.next(); i++ {
+		if colorFn != nil {
+			color = fs.vertexColor(colorFn(i, l.codepoint))
+		}
+		if l.inked() {
+			if track {
+				e.add(&l.q)
+			}
+			fs.emitQuad(state, xf, &l.q, l.page, color)
+		}
+	}
+	x, y = l.x, l.y
+	if track {
+		fs.finishExtent(state, f, &e, x, y, bounds, false)
+	}
 
-	scale := float32(1.0)
+	if !vertical && state.Decoration != 0 {
+		fs.drawDecorations(f, state, startX, x, y)
+	}
+	fs.flush()
 
-	if state.Align&AlignLeft != 0 {
-		// empty
-	} else if state.Align&AlignRight != 0 {
-		width := fs.TextBounds(x, y, str, nil)
-		x -= width
-	} else if state.Align&AlignCenter != 0 {
-		width := fs.TextBounds(x, y, str, nil)
-		x -= width * 0.5
+	if vertical {
+		return y
 	}
+	return x
+}
 
-	y += fs.getVertAlign(f, state.Align, isize)
+// emitQuad clips, transforms and queues a quad sampling atlas page.
+func (fs *FontStash) emitQuad(state *State, xf *[6]float32, q *Quad, page int, color uint32) {
+	if !clipEmpty(&state.Clip) && !clipQuad(q, &state.Clip) {
+		return
+	}
+	if fs.quadFn != nil {
+		fs.quadFn(*q, color)
+		return
+	}
+	if fs.NVerts+vertsPerQuad > fs.Params.MaxVertices { // FONS_VERTEX_COUNT
+		fs.flush()
+	}
+	if n := len(fs.batches); n == 0 || fs.batches[n-1].page != page {
+		fs.batches = append(fs.batches, batchSpan{page: page, start: fs.NVerts})
+	}
 
-	q := Quad{}
-	prevGlyphIndex := -1
+	fs.vertex(xf, q.X0, q.Y0, q.S0, q.T0, color)
+	fs.vertex(xf, q.X1, q.Y1, q.S1, q.T1, color)
+	fs.vertex(xf, q.X1, q.Y0, q.S1, q.T0, color)
 
-	for _, codepoint := range str {
-		glyph, err := fs.getGlyph(f, codepoint, isize, iblur)
-		if err != nil {
-			continue // Or stop?
-		}
-		if glyph != nil {
-			fs.getQuad(f, prevGlyphIndex, glyph, scale, state.Spacing, &x, &y, &q)
+	fs.vertex(xf, q.X0, q.Y0, q.S0, q.T0, color)
+	fs.vertex(xf, q.X0, q.Y1, q.S0, q.T1, color)
+	fs.vertex(xf, q.X1, q.Y1, q.S1, q.T1, color)
+}
 
-			if fs.NVerts+vertsPerQuad > maxVertices { // FONS_VERTEX_COUNT
-				fs.flush()
-			}
+// drawDecorations queues the state's decoration lines for text spanning
+// x0 to x1 on the baseline y.
+func (fs *FontStash) drawDecorations(f *Font, state *State, x0, x1, y float32) {
+	thickness := max(1, f.underlineThickness*state.layoutSize())
+	line := func(pos float32) {
+		// Positive font units point up the screen.
+		center := y - fs.ySign*pos*state.layoutSize()
+		fs.DrawRect(x0, center-thickness*0.5, x1, center+thickness*0.5, state.Color)
+	}
+	if state.Decoration&DecorationUnderline != 0 {
+		// The post table gives the top of the underline.
+		line(f.underlinePosition - f.underlineThickness*0.5)
+	}
+	if state.Decoration&DecorationStrikethrough != 0 {
+		line(f.strikePosition)
+	}
+}
 
-			fs.vertex(q.X0, q.Y0, q.S0, q.T0, state.Color)
-			fs.vertex(q.X1, q.Y1, q.S1, q.T1, state.Color)
-			fs.vertex(q.X1, q.Y0, q.S1, q.T0, state.Color)
+// alignX shifts x by the state's horizontal alignment, measuring if needed.
+func (fs *FontStash) alignX(state *State, x, y float32, text runeIter, bounds *[4]float32) (float32, bool) {
+	if state.Align&AlignLeft != 0 || state.Align&(AlignRight|AlignCenter) == 0 {
+		// Left aligned, so no need to measure.
+		return x, false
+	}
+	_, shift := fs.textExtent(x, y, text, bounds, false)
+	return x - shift, true
+}
 
-			fs.vertex(q.X0, q.Y0, q.S0, q.T0, state.Color)
-			fs.vertex(q.X0, q.Y1, q.S0, q.T1, state.Color)
-			fs.vertex(q.X1, q.Y1, q.S1, q.T1, state.Color)
+// CaretPositions returns the pen x position of each rune of str, after
+// kerning against the rune before it, plus the position after the last
+// rune, so the result has one more entry than str has runes. Positions
+// include kerning, spacing and the horizontal alignment of the current
+// state, matching where DrawText places glyphs.
+func (fs *FontStash) CaretPositions(x float32, str string) []float32 {
+	carets := make([]float32, 0, utf8.RuneCountInString(str)+1)
+	state := fs.getState()
+	f := fs.getFont(state.Font)
+	if f == nil {
+		for range utf8.RuneCountInString(str) + 1 {
+			carets = append(carets, x)
 		}
-		if glyph != nil {
-			prevGlyphIndex = glyph.Index
-		} else {
-			prevGlyphIndex = -1
+		return carets
+	}
+	text := runeIter{s: str}
+	x, _ = fs.alignX(state, x, 0, text, nil)
+
+	l := fs.newLayout(state, f, text, x, 0, false)
+	for l.next() {
+		carets = append(carets, l.penX)
+	}
+	return append(carets, l.x)
+}
+
+// GlyphAdvances stores the advance of each of runes in out, growing it if
+// needed, and returns it resliced to len(runes). Each advance includes the
+// kerning against the previous rune and the state's spacing, so the sum is
+// the width DrawText would advance. No vertices are produced.
+func (fs *FontStash) GlyphAdvances(runes []rune, out []float32) []float32 {
+	if cap(out) < len(runes) {
+		out = make([]float32, len(runes))
+	}
+	out = out[:len(runes)]
+	clear(out)
+
+	state := fs.getState()
+	f := fs.getFont(state.Font)
+	if f == nil {
+		return out
+	}
+	l := fs.newLayout(state, f, runeIter{r: runes}, 0, 0, false)
+	for i := 0; ; i++ {
+		start := l.x
+		if !l.next() {
+			break
 		}
+		out[i] = l.x - start
 	}
-	fs.flush()
+	return out
+}
 
-	return x
+// IndexAtX returns the caret index in str closest to pointerX for text
+// drawn at x, suitable for click-to-position in text fields. The result is
+// a rune index in the range [0, number of runes].
+func (fs *FontStash) IndexAtX(x, pointerX float32, str string) int {
+	carets := fs.CaretPositions(x, str)
+	for i := 0; i < len(carets)-1; i++ {
+		if pointerX < (carets[i]+carets[i+1])*0.5 {
+			return i
+		}
+	}
+	return len(carets) - 1
 }
 
-// TextBounds measures the text bounds.
-func (fs *FontStash) TextBounds(x, y float32, str string, bounds *[4]float32) float32 {
+// CacheGlyphs rasterizes every rune of str into the atlas ahead of time,
+// e.g. during a loading screen, so the first frame that draws them doesn't
+// stall. Glyphs are cached for the current state's font, size and blur, so
+// set those first. It returns the first error encountered, such as
+// ErrAtlasFull.
+func (fs *FontStash) CacheGlyphs(str string) error {
 	state := fs.getState()
-	if state.Font < 0 || state.Font >= len(fs.Fonts) {
-		return 0
+	f := fs.getFont(state.Font)
+	if f == nil {
+		return ErrInvalidFont
 	}
-	f := fs.Fonts[state.Font]
-	isize := int16(state.Size * sizeScale)
+	isize := fs.sizeKey(state.layoutSize())
 	iblur := int16(state.Blur)
-	scale := float32(1.0)
 
-	y += fs.getVertAlign(f, state.Align, isize)
+	for _, codepoint := range str {
+		if _, err := fs.getGlyph(f, codepoint, isize, iblur, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	minx, maxx := x, x
-	miny, maxy := y, y
-	startx := x
+// CacheGlyphsSorted is CacheGlyphs for the unique runes of str in
+// codepoint order, so the atlas layout, and what SaveAtlas writes, depends
+// only on the set of runes and not on the order they appear in. Drawing
+// still packs glyphs in the order they are first needed: for a
+// reproducible atlas, cache every glyph the draws will use this way first.
+func (fs *FontStash) CacheGlyphsSorted(str string) error {
+	runes := []rune(str)
+	slices.Sort(runes)
+	return fs.CacheGlyphs(string(slices.Compact(runes)))
+}
 
-	q := Quad{}
-	prevGlyphIndex := -1
+// CacheGlyphRange is like CacheGlyphs for every codepoint from lo to hi
+// inclusive, such as a whole script's block. Codepoints that neither the
+// font nor its fallbacks cover are skipped rather than cached as the
+// missing glyph. It stops at the first error, returning ErrAtlasFull if
+// the atlas overflows and the error callback did not make room.
+func (fs *FontStash) CacheGlyphRange(lo, hi rune) error {
+	state := fs.getState()
+	f := fs.getFont(state.Font)
+	if f == nil {
+		return ErrInvalidFont
+	}
+	isize := fs.sizeKey(state.layoutSize())
+	iblur := int16(state.Blur)
 
-	for _, codepoint := range str {
-		glyph, err := fs.getGlyph(f, codepoint, isize, iblur)
-		if err != nil {
+	for codepoint := max(lo, 0); codepoint <= min(hi, unicode.MaxRune); codepoint++ {
+		if _, index := fs.resolveGlyph(f, codepoint); index == 0 {
 			continue
 		}
-		if glyph != nil {
-			fs.getQuad(f, prevGlyphIndex, glyph, scale, state.Spacing, &x, &y, &q)
-			if q.X0 < minx {
-				minx = q.X0
-			}
-			if q.X1 > maxx {
-				maxx = q.X1
-			}
-			if fs.Params.Flags&ZeroTopLeft != 0 {
-				if q.Y0 < miny {
-					miny = q.Y0
-				}
-				if q.Y1 > maxy {
-					maxy = q.Y1
-				}
-			} else {
-				if q.Y1 < miny {
-					miny = q.Y1
-				}
-				if q.Y0 > maxy {
-					maxy = q.Y0
-				}
-			}
+		if _, err := fs.getGlyph(f, codepoint, isize, iblur, 0); err != nil {
+			return err
 		}
-		if glyph != nil {
-			prevGlyphIndex = glyph.Index
-		} else {
-			prevGlyphIndex = -1
+	}
+	return nil
+}
+
+// TextBounds measures the text bounds. The bounds enclose the ink and the
+// pen origin after the current transform. The returned advance is how far
+// the pen moves, untransformed, and includes the last glyph's full advance
+// and any trailing spaces; TextMetrics gives the advance and ink extent
+// separately.
+func (fs *FontStash) TextBounds(x, y float32, str string, bounds *[4]float32) float32 {
+	return fs.textBounds(x, y, runeIter{s: str}, bounds, false)
+}
+
+// TextBoundsBytes is like TextBounds but reads UTF-8 text from b.
+func (fs *FontStash) TextBoundsBytes(x, y float32, b []byte, bounds *[4]float32) float32 {
+	return fs.textBounds(x, y, runeIter{b: b}, bounds, false)
+}
+
+// TextLineBounds is like TextBounds but the vertical bounds are the line
+// box, from the font's ascender to its descender, rather than the ink. Text
+// without ascenders or descenders, such as "ace", then measures as tall as
+// any other line, which is what stacking lines needs. Vertical text is
+// measured as in TextBounds.
+func (fs *FontStash) TextLineBounds(x, y float32, str string, bounds *[4]float32) float32 {
+	return fs.textBounds(x, y, runeIter{s: str}, bounds, true)
+}
+
+// textBounds measures text; lineBox replaces the ink's vertical extent of
+// horizontal text with the ascender to descender box.
+func (fs *FontStash) textBounds(x, y float32, text runeIter, bounds *[4]float32, lineBox bool) float32 {
+	advance, _ := fs.textExtent(x, y, text, bounds, lineBox)
+	return advance
+}
+
+// textExtent is textBounds, also returning how far horizontal alignment
+// moves the text left of x.
+func (fs *FontStash) textExtent(x, y float32, text runeIter, bounds *[4]float32, lineBox bool) (advance, shift float32) {
+	text = fs.normalized(text)
+	state := fs.getState()
+	f := fs.getFont(state.Font)
+	if f == nil {
+		return 0, 0
+	}
+	vertical := state.Direction == DirTTB
+	if !vertical {
+		y = fs.snapBaseline(state, y+fs.getVertAlign(f, state.Align, fs.sizeKey(state.layoutSize())))
+	}
+
+	e := newLineExtent(x, y)
+	l := fs.newLayout(state, f, text, x, y, vertical)
+	for l.next() {
+		if l.inked() {
+			e.add(&l.q)
 		}
 	}
+	return fs.finishExtent(state, f, &e, l.x, l.y, bounds, lineBox)
+}
+
+// lineExtent accumulates the box around a line's pen origin and glyph
+// quads as it is laid out.
+type lineExtent struct {
+	startx, starty         float32
+	minx, miny, maxx, maxy float32
+}
 
-	advance := x - startx
+func newLineExtent(x, y float32) lineExtent {
+	return lineExtent{startx: x, starty: y, minx: x, miny: y, maxx: x, maxy: y}
+}
 
-	if state.Align&AlignLeft != 0 {
-		// empty
-	} else if state.Align&AlignRight != 0 {
-		minx -= advance
-		maxx -= advance
-	} else if state.Align&AlignCenter != 0 {
-		minx -= advance * 0.5
-		maxx -= advance * 0.5
+// add grows the extent to enclose the glyph quad q.
+func (e *lineExtent) add(q *Quad) {
+	e.minx = min(e.minx, q.X0)
+	e.maxx = max(e.maxx, q.X1)
+	e.miny = min(e.miny, q.Y0, q.Y1)
+	e.maxy = max(e.maxy, q.Y0, q.Y1)
+}
+
+// finishExtent turns e, laid out up to the pen position x, y, into the
+// advance, alignment shift and bounds textExtent reports.
+func (fs *FontStash) finishExtent(state *State, f *Font, e *lineExtent, x, y float32, bounds *[4]float32, lineBox bool) (advance, shift float32) {
+	minx, miny, maxx, maxy := e.minx, e.miny, e.maxx, e.maxy
+	vertical := state.Direction == DirTTB
+	if lineBox && !vertical {
+		top := e.starty - fs.ySign*f.Ascender*state.layoutSize()
+		bottom := e.starty - fs.ySign*f.Descender*state.layoutSize()
+		miny, maxy = min(top, bottom), max(top, bottom)
+	}
+	if iblur := int16(state.Blur); fs.Params.BlurBounds && iblur > 0 {
+		pad := float32(min(iblur, maxBlur))
+		minx, miny = minx-pad, miny-pad
+		maxx, maxy = maxx+pad, maxy+pad
+	}
+
+	advance = x - e.startx
+	if vertical {
+		// The column spans every em box, not just the ink.
+		advance = float32(math.Abs(float64(y - e.starty)))
+		miny = min(miny, y)
+		maxy = max(maxy, y)
+	} else {
+		shift = alignShift(state.Align, advance)
+		minx -= shift
+		maxx -= shift
+	}
+
+	if m := &state.Transform; *m != identityTransform {
+		minx, miny, maxx, maxy = transformBounds(m, minx, miny, maxx, maxy)
 	}
 
 	if bounds != nil {
@@ -674,44 +2136,148 @@ func (fs *FontStash) TextBounds(x, y float32, str string, bounds *[4]float32) fl
 		bounds[3] = maxy
 	}
 
-	return advance
+	return advance, shift
+}
+
+// alignShift returns how far left of the pen origin the horizontal
+// alignment flags in align move text with the given advance.
+func alignShift(align int, advance float32) float32 {
+	if align&AlignLeft != 0 {
+		// empty
+	} else if align&AlignRight != 0 {
+		return advance
+	} else if align&AlignCenter != 0 {
+		return advance * 0.5
+	}
+	return 0
+}
+
+// transformPoint applies the affine matrix m to x, y.
+func transformPoint(m *[6]float32, x, y float32) (float32, float32) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+// transformBounds returns the axis-aligned box enclosing the box
+// minx, miny, maxx, maxy after transforming its corners by m.
+func transformBounds(m *[6]float32, minx, miny, maxx, maxy float32) (float32, float32, float32, float32) {
+	x0, y0 := transformPoint(m, minx, miny)
+	bx0, by0, bx1, by1 := x0, y0, x0, y0
+	for _, c := range [][2]float32{{maxx, miny}, {maxx, maxy}, {minx, maxy}} {
+		x, y := transformPoint(m, c[0], c[1])
+		bx0, by0 = min(bx0, x), min(by0, y)
+		bx1, by1 = max(bx1, x), max(by1, y)
+	}
+	return bx0, by0, bx1, by1
+}
+
+// TextMetrics separates the advance, ink and line extents of a run of
+// text. Ink coordinates are relative to the pen origin on the baseline and
+// follow the Zero* y direction; the line extents are font metrics, with
+// LineDescent negative below the baseline.
+type TextMetrics struct {
+	Advance                            float32
+	InkMinX, InkMinY, InkMaxX, InkMaxY float32
+	LineAscent, LineDescent            float32
+}
+
+// TextMetrics measures str with the current state, ignoring alignment and
+// transform. Unlike TextBounds, the ink box covers only the glyph quads and
+// not the pen origin, so it is all zero for text with no visible glyphs.
+func (fs *FontStash) TextMetrics(str string) TextMetrics {
+	var m TextMetrics
+	state := fs.getState()
+	f := fs.getFont(state.Font)
+	if f == nil {
+		return m
+	}
+	m.LineAscent = f.Ascender * state.layoutSize()
+	m.LineDescent = f.Descender * state.layoutSize()
+
+	inked := false
+	l := fs.newLayout(state, f, runeIter{s: str}, 0, 0, false)
+	for l.next() {
+		if !l.inked() {
+			continue
+		}
+		q := &l.q
+		minY, maxY := min(q.Y0, q.Y1), max(q.Y0, q.Y1)
+		if !inked {
+			m.InkMinX, m.InkMinY, m.InkMaxX, m.InkMaxY = q.X0, minY, q.X1, maxY
+			inked = true
+		}
+		m.InkMinX = min(m.InkMinX, q.X0)
+		m.InkMinY = min(m.InkMinY, minY)
+		m.InkMaxX = max(m.InkMaxX, q.X1)
+		m.InkMaxY = max(m.InkMaxY, maxY)
+	}
+	m.Advance = l.x
+	return m
 }
 
 // VertMetrics returns the vertical metrics for the current font.
 func (fs *FontStash) VertMetrics() (ascender, descender, lineHeight float32) {
 	state := fs.getState()
-	if state.Font < 0 || state.Font >= len(fs.Fonts) {
+	f := fs.getFont(state.Font)
+	if f == nil {
 		return 0, 0, 0
 	}
-	f := fs.Fonts[state.Font]
-	size := state.Size
+	size := state.layoutSize()
 
 	return f.Ascender * size, f.Descender * size, f.LineHeight * size
 }
 
+// FontMetrics returns a font's units per em and its ascent, descent and
+// line gap in design units, unscaled and unrounded, as read from the hhea
+// table or from OS/2 when the font asks for its typographic metrics. The
+// descent is negative below the baseline, as in the tables, so
+// ascent - descent + lineGap is the design line height. All values are 0
+// for an invalid handle.
+func (fs *FontStash) FontMetrics(fontHandle int) (unitsPerEm, ascent, descent, lineGap int) {
+	f := fs.getFont(fontHandle)
+	if f == nil {
+		return 0, 0, 0, 0
+	}
+	return f.unitsPerEm, f.ascent, f.descent, f.lineGap
+}
+
+// UnderlineMetrics returns where a font's underline sits at the given
+// size, in pixels as DrawText draws it with DecorationUnderline: position
+// is the distance of the line's top above the baseline, negative below,
+// and thickness is its height, at least 1. They come from the post table,
+// or when it has none are derived from the vertical metrics, half the
+// descender below the baseline and a sixteenth of the line's ascender to
+// descender height thick. Both are 0 for an invalid handle.
+func (fs *FontStash) UnderlineMetrics(fontHandle int, size float32) (position, thickness float32) {
+	f := fs.getFont(fontHandle)
+	if f == nil {
+		return 0, 0
+	}
+	return f.underlinePosition * size, max(1, f.underlineThickness*size)
+}
+
 // LineBounds returns the vertical bounds for the current font at the given line position.
 func (fs *FontStash) LineBounds(y float32) (miny, maxy float32) {
 	state := fs.getState()
-	if state.Font < 0 || state.Font >= len(fs.Fonts) {
+	f := fs.getFont(state.Font)
+	if f == nil {
 		return y, y
 	}
-	f := fs.Fonts[state.Font]
-	isize := int16(state.Size * sizeScale)
-	size := state.Size
+	isize := fs.sizeKey(state.layoutSize())
+	size := state.layoutSize()
 
 	y += fs.getVertAlign(f, state.Align, isize)
 
-	if fs.Params.Flags&ZeroTopLeft != 0 {
-		miny = y - f.Ascender*size
-		maxy = miny + f.LineHeight*size
-	} else {
-		maxy = y + f.Descender*size
-		miny = maxy - f.LineHeight*size
-	}
-	return
+	top := y - fs.ySign*f.Ascender*size
+	bottom := top + fs.ySign*f.LineHeight*size
+	return min(top, bottom), max(top, bottom)
 }
 
-// ExpandAtlas expands the font atlas to the given dimensions.
+// ExpandAtlas expands the font atlas to the given dimensions, keeping the
+// cached glyphs. The atlas never shrinks: a dimension smaller than the
+// current one is left as is, so use ResetAtlas to get a smaller atlas. It
+// returns false and leaves the atlas unchanged if the size exceeds
+// Params.MaxAtlasSize, the renderer fails to resize or the atlas has more
+// than one page.
 func (fs *FontStash) ExpandAtlas(width, height int) bool {
 	width = maxInt(width, fs.Params.Width)
 	height = maxInt(height, fs.Params.Height)
@@ -719,45 +2285,45 @@ func (fs *FontStash) ExpandAtlas(width, height int) bool {
 	if width == fs.Params.Width && height == fs.Params.Height {
 		return true
 	}
+	if width > fs.Params.MaxAtlasSize || height > fs.Params.MaxAtlasSize || len(fs.pages) > 0 {
+		return false
+	}
 
 	// Flush pending glyphs
 	fs.flush()
 
 	// Create new texture in renderer
-	if fs.Params.Renderer != nil {
-		fs.Params.Renderer.Resize(width, height)
+	if !fs.resizeRenderer(width, height) {
+		return false
 	}
 
 	// Copy old texture data
-	newTexData := make([]byte, width*height)
-	for i := 0; i < fs.Params.Height; i++ {
-		src := fs.TexData[i*fs.Params.Width : i*fs.Params.Width+fs.Params.Width]
-		dst := newTexData[i*width : i*width+fs.Params.Width]
-		copy(dst, src)
+	if !fs.Params.MeasureOnly {
+		newTexData := make([]byte, width*height)
+		for i := 0; i < fs.Params.Height; i++ {
+			src := fs.TexData[i*fs.Params.Width : i*fs.Params.Width+fs.Params.Width]
+			dst := newTexData[i*width : i*width+fs.Params.Width]
+			copy(dst, src)
+		}
+		fs.TexData = newTexData
 	}
 
-	fs.TexData = newTexData
-
 	// Increase atlas size
 	fs.Atlas.expand(width, height)
 
-	// Add existing data as dirty
-	maxy := 0
-	for _, n := range fs.Atlas.nodes {
-		if int(n.y) > maxy {
-			maxy = int(n.y)
+	// Add existing data as dirty. Texels outside the glyphs' rows and the
+	// newly exposed region hold no glyphs yet, so they never need
+	// uploading; glyphs packed there later upload their own cells.
+	if !fs.Params.RendererPreservesOnResize {
+		// The renderer may have dropped the texels on Resize.
+		maxy := 0
+		for _, n := range fs.Atlas.nodes {
+			if int(n.y) > maxy {
+				maxy = int(n.y)
+			}
 		}
+		fs.Dirty = image.Rect(0, 0, width, maxy)
 	}
-	// Dirty rect logic: inverted init, then expand to cover valid area
-	// Here we expand to cover the existing valid nodes.
-	// But effectively the dirty rect should cover the valid area if we copied it.
-	// Actually, ExpandAtlas copies old data to new buffer.
-	// So we might want to mark the copied area as dirty? Or not?
-	// The renderer's Resize might clear texture or keep it.
-	// If renderer keeps content on Resize, we don't need to re-upload everything?
-	// But generic renderer might lose content.
-	// The safest is to mark everything dirty.
-	fs.Dirty = image.Rect(0, 0, width, maxy)
 
 	fs.Params.Width = width
 	fs.Params.Height = height
@@ -766,30 +2332,47 @@ func (fs *FontStash) ExpandAtlas(width, height int) bool {
 	fs.Itw = 1.0 / float32(width)
 	fs.Ith = 1.0 / float32(height)
 
+	if cb := fs.Params.AtlasChangedCallback; cb != nil {
+		cb(width, height, AtlasExpanded)
+	}
 	return true
 }
 
-// ResetAtlas resets the atlas to the given dimensions.
+// ResetAtlas resets the atlas to the given dimensions, which may be
+// smaller than the current ones, and drops every cached glyph. It returns
+// false and leaves the atlas unchanged if the size is not positive, exceeds
+// Params.MaxAtlasSize or the renderer fails to resize.
 func (fs *FontStash) ResetAtlas(width, height int) bool {
+	if width <= 0 || height <= 0 || width > fs.Params.MaxAtlasSize || height > fs.Params.MaxAtlasSize {
+		return false
+	}
+
 	// Flush pending glyphs
 	fs.flush()
 
 	// Create new texture in renderer
-	if fs.Params.Renderer != nil {
-		fs.Params.Renderer.Resize(width, height)
+	if !fs.resizeRenderer(width, height) {
+		return false
 	}
 
 	// Reset atlas
 	fs.Atlas.reset(width, height)
+	fs.pages = nil
 
 	// Clear texture data
-	fs.TexData = make([]byte, width*height)
+	fs.TexData = newTexData(fs.Params.MeasureOnly, width, height)
 
 	// Reset dirty rect
 	fs.Dirty = image.Rectangle{Min: image.Point{width, height}, Max: image.Point{0, 0}}
+	fs.dirtyRects = fs.dirtyRects[:0]
+
+	fs.CloseFaces()
 
 	// Reset cached glyphs
 	for _, font := range fs.Fonts {
+		if font == nil {
+			continue
+		}
 		font.Glyphs = font.Glyphs[:0]
 		for i := range font.Lut {
 			font.Lut[i] = -1
@@ -804,61 +2387,245 @@ func (fs *FontStash) ResetAtlas(width, height int) bool {
 	fs.Ith = 1.0 / float32(height)
 
 	// Add white rect
-	fs.addWhiteRect(whiteRectSize, whiteRectSize)
+	fs.addWhiteRect(fs.Params.WhiteRectSize, fs.Params.WhiteRectSize)
 
+	if cb := fs.Params.AtlasChangedCallback; cb != nil {
+		cb(width, height, AtlasReset)
+	}
 	return true
 }
 
+// RepackAtlas packs every cached glyph into a fresh skyline, tallest
+// first, reclaiming space left behind by glyphs dropped with RemoveFont,
+// SetHinting or fallback changes, without discarding the cache. Glyph
+// coordinates are updated and the whole used area is uploaded on the next
+// flush. Regions from ReserveRect are not tracked and are lost, as with
+// ResetAtlas. It copies the entire atlas, so run it between frames rather
+// than on the hot path. It returns false and leaves the atlas unchanged if
+// the glyphs don't fit or the atlas has more than one page.
+func (fs *FontStash) RepackAtlas() bool {
+	if fs.Params.MeasureOnly {
+		return true
+	}
+	if len(fs.pages) > 0 {
+		return false
+	}
+	fs.flush()
+
+	type cell struct {
+		glyph *Glyph // nil for the white rect
+		src   image.Rectangle
+		dst   image.Point
+	}
+	var cells []cell
+	if !fs.whiteRect.Empty() {
+		cells = append(cells, cell{src: fs.whiteRect})
+	}
+	for _, f := range fs.Fonts {
+		if f == nil {
+			continue
+		}
+		for i := range f.Glyphs {
+			if g := &f.Glyphs[i]; !g.empty() {
+				cells = append(cells, cell{glyph: g, src: image.Rect(int(g.X0), int(g.Y0), int(g.X1), int(g.Y1))})
+			}
+		}
+	}
+	slices.SortStableFunc(cells, func(a, b cell) int {
+		if d := b.src.Dy() - a.src.Dy(); d != 0 {
+			return d
+		}
+		return b.src.Dx() - a.src.Dx()
+	})
+
+	width, height := fs.Params.Width, fs.Params.Height
+	atlas := newAtlas(width, height, max(fs.Params.AtlasNodeCapacity, len(fs.Atlas.nodes)))
+	for i := range cells {
+		x, y, ok := atlas.addRect(cells[i].src.Dx(), cells[i].src.Dy())
+		if !ok {
+			return false
+		}
+		cells[i].dst = image.Pt(x, y)
+	}
+
+	tex := make([]byte, width*height)
+	maxy := 0
+	for _, c := range cells {
+		for y := 0; y < c.src.Dy(); y++ {
+			src := (c.src.Min.Y+y)*width + c.src.Min.X
+			copy(tex[(c.dst.Y+y)*width+c.dst.X:], fs.TexData[src:src+c.src.Dx()])
+		}
+		r := image.Rectangle{Min: c.dst, Max: c.dst.Add(c.src.Size())}
+		if c.glyph == nil {
+			fs.whiteRect = r
+		} else {
+			c.glyph.X0, c.glyph.Y0 = int32(r.Min.X), int32(r.Min.Y)
+			c.glyph.X1, c.glyph.Y1 = int32(r.Max.X), int32(r.Max.Y)
+		}
+		maxy = max(maxy, r.Max.Y)
+	}
+
+	fs.Atlas = atlas
+	fs.TexData = tex
+	fs.Dirty = image.Rectangle{Min: image.Point{width, height}, Max: image.Point{0, 0}}
+	fs.dirtyRects = fs.dirtyRects[:0]
+	fs.markDirty(0, 0, width, maxy)
+	return true
+}
+
+// AtlasNodeCount returns the number of nodes in the atlas packer's
+// skyline, for tuning Params.AtlasNodeCapacity.
+func (fs *FontStash) AtlasNodeCount() int {
+	return len(fs.Atlas.nodes)
+}
+
+// resizeRenderer asks the renderer for a texture of the given size,
+// reporting false if a CheckedResizer refuses.
+func (fs *FontStash) resizeRenderer(width, height int) bool {
+	switch r := fs.Params.Renderer.(type) {
+	case nil:
+	case CheckedResizer:
+		return r.TryResize(width, height) == nil
+	default:
+		r.Resize(width, height)
+	}
+	return true
+}
+
+// dirtyRectsCover reports whether the tracked dirty rects span Dirty exactly.
+func (fs *FontStash) dirtyRectsCover() bool {
+	if len(fs.dirtyRects) == 0 {
+		return false
+	}
+	union := fs.dirtyRects[0]
+	for _, r := range fs.dirtyRects[1:] {
+		union = union.Union(r)
+	}
+	return union == fs.Dirty
+}
+
+// packRegion copies the atlas texels in r row by row into a reused
+// buffer with no gaps between rows.
+func (fs *FontStash) packRegion(r image.Rectangle) []byte {
+	w := r.Dx()
+	if n := w * r.Dy(); cap(fs.tightBuf) < n {
+		fs.tightBuf = make([]byte, n)
+	} else {
+		fs.tightBuf = fs.tightBuf[:n]
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		src := y*fs.Params.Width + r.Min.X
+		copy(fs.tightBuf[(y-r.Min.Y)*w:], fs.TexData[src:src+w])
+	}
+	return fs.tightBuf
+}
+
 func (fs *FontStash) flush() {
 	// Flush texture
 	if fs.Dirty.Min.X < fs.Dirty.Max.X && fs.Dirty.Min.Y < fs.Dirty.Max.Y {
-		if fs.Params.Renderer != nil {
-			// Check bounds?
-			// The dirty rect might be larger than texture?
-			// fs.Dirty is in texture coords.
-			fs.Params.Renderer.Update(fs.Dirty, fs.TexData, fs.Params.Width)
+		// Glyph padding can push the dirty rect past the texture edge, so
+		// clamp it to keep renderers from indexing outside TexData.
+		bounds := image.Rect(0, 0, fs.Params.Width, fs.Params.Height)
+		dirty := fs.Dirty.Intersect(bounds)
+		if multi, ok := fs.Params.Renderer.(MultiUpdater); ok && fs.dirtyRectsCover() {
+			rects := fs.dirtyRects[:0]
+			for _, r := range fs.dirtyRects {
+				if r = r.Intersect(bounds); !r.Empty() {
+					rects = append(rects, r)
+				}
+			}
+			if len(rects) > 0 {
+				multi.MultiUpdate(rects, fs.TexData, fs.Params.Width)
+			}
+		} else if tight, ok := fs.Params.Renderer.(TightUpdater); ok && !dirty.Empty() {
+			tight.UpdateTight(dirty, fs.packRegion(dirty), dirty.Dx())
+		} else if fs.Params.Renderer != nil && !dirty.Empty() {
+			fs.Params.Renderer.Update(dirty, fs.TexData, fs.Params.Width)
 		}
 		// Reset dirty rect
 		fs.Dirty = image.Rectangle{Min: image.Point{fs.Params.Width, fs.Params.Height}, Max: image.Point{0, 0}}
 	}
+	fs.dirtyRects = fs.dirtyRects[:0]
+	fs.flushPages()
 
 	// Flush triangles
 	if fs.NVerts > 0 {
 		if fs.Params.Renderer != nil {
-			// Convert fs.Verts, fs.TCoords, fs.Colors to []Vertex
-			verts := make([]Vertex, fs.NVerts)
-			for i := 0; i < fs.NVerts; i++ {
-				verts[i] = Vertex{
-					X:     fs.Verts[i*2],
-					Y:     fs.Verts[i*2+1],
-					U:     fs.TCoords[i*2],
-					V:     fs.TCoords[i*2+1],
-					Color: fs.Colors[i],
-				}
-			}
-			fs.Params.Renderer.Draw(verts)
+			fs.draws += uint64(len(fs.batches))
+			fs.drawnVerts += uint64(fs.NVerts)
+		}
+		if batcher, ok := fs.Params.Renderer.(BatchRenderer); ok {
+			batcher.DrawBatch(fs.queuedBatches())
+		} else if raw, ok := fs.Params.Renderer.(RawRenderer); ok {
+			raw.DrawRaw(fs.Verts, fs.TCoords, fs.Colors, fs.NVerts)
+		} else if fs.Params.Renderer != nil {
+			fs.Params.Renderer.Draw(fs.queuedVertices())
 		}
-		fs.NVerts = 0
-		fs.Verts = fs.Verts[:0]
-		fs.TCoords = fs.TCoords[:0]
-		fs.Colors = fs.Colors[:0]
+		fs.dropVerts()
 	}
 }
 
-func (fs *FontStash) blurCols(x, y, w, h, stride, alpha int) {
-	dst := fs.TexData
+// dropVerts empties the vertex queue.
+func (fs *FontStash) dropVerts() {
+	fs.NVerts = 0
+	fs.Verts = fs.Verts[:0]
+	fs.TCoords = fs.TCoords[:0]
+	fs.Colors = fs.Colors[:0]
+	fs.batches = fs.batches[:0]
+}
+
+// batchSpan marks where a run of queued vertices sampling one atlas page
+// starts.
+type batchSpan struct {
+	page, start int
+}
+
+// queuedBatches converts the queued vertices to one Batch per run on the
+// same atlas page.
+func (fs *FontStash) queuedBatches() []Batch {
+	verts := fs.queuedVertices()
+	batches := make([]Batch, len(fs.batches))
+	for i, span := range fs.batches {
+		end := len(verts)
+		if i+1 < len(fs.batches) {
+			end = fs.batches[i+1].start
+		}
+		batches[i] = Batch{Texture: span.page, Vertices: verts[span.start:end]}
+	}
+	return batches
+}
+
+// queuedVertices converts the queued vertex arrays to []Vertex.
+func (fs *FontStash) queuedVertices() []Vertex {
+	verts := make([]Vertex, fs.NVerts)
+	for i := range verts {
+		verts[i] = Vertex{
+			X:     fs.Verts[i*2],
+			Y:     fs.Verts[i*2+1],
+			U:     fs.TCoords[i*2],
+			V:     fs.TCoords[i*2+1],
+			Color: fs.Colors[i],
+		}
+	}
+	return verts
+}
+
+func (fs *FontStash) blurCols(dst []byte, x, y, w, h, stride, alpha int) {
+	if h < 2 {
+		return
+	}
 	for c := 0; c < w; c++ {
 		offset := y*stride + x + c
 		z := 0
 		for r := stride; r < h*stride; r += stride {
 			z += (alpha * ((int(dst[offset+r]) << 7) - z)) >> 16
-			dst[offset+r] = uint8(z >> 7)
+			dst[offset+r] = uint8((z + 64) >> 7)
 		}
 		dst[offset+(h-1)*stride] = 0
 		z = 0
 		for r := (h - 2) * stride; r >= 0; r -= stride {
 			z += (alpha * ((int(dst[offset+r]) << 7) - z)) >> 16
-			dst[offset+r] = uint8(z >> 7)
+			dst[offset+r] = uint8((z + 64) >> 7)
 		}
 		dst[offset] = 0
 	}
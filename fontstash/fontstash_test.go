@@ -1,19 +1,32 @@
 package fontstash
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"image"
+	"math"
+	"slices"
+	"sync"
 	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
 )
 
 type MockRenderer struct {
 	Updates int
 	Draws   int
 	Verts   int
+	Rects   []image.Rectangle
 }
 
 func (r *MockRenderer) Resize(width, height int) {}
 func (r *MockRenderer) Update(rect image.Rectangle, data []byte, imgWidth int) {
 	r.Updates++
+	r.Rects = append(r.Rects, rect)
 }
 func (r *MockRenderer) Draw(verts []Vertex) {
 	r.Draws++
@@ -103,3 +116,2774 @@ func TestFlushMemoryLeak(t *testing.T) {
 		// Just noting that capacity might be non-zero, which is expected.
 	}
 }
+
+func TestFallbackGlyphAdvance(t *testing.T) {
+	// No CJK font ships with the test data, so use a symbol the bundled
+	// serif lacks but the Go font covers.
+	const missing = '→'
+
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	base, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	// Both fonts have 2048 units per em, so give the fallback 1000 to
+	// check its glyphs are scaled by its own em.
+	head := slices.Clone(fontTable(goregular.TTF, 0, "head"))
+	binary.BigEndian.PutUint16(head[18:], 1000)
+	fallback, err := fs.AddFontFromBytes("go", withTables(goregular.TTF, map[string][]byte{"head": head}))
+	if err != nil {
+		t.Fatalf("Failed to load fallback font: %v", err)
+	}
+	if fs.getGlyphIndex(fs.Fonts[base], missing) != 0 {
+		t.Fatalf("Expected base font to lack %q", missing)
+	}
+	if got, other := fs.Fonts[fallback].sfnt.UnitsPerEm(), fs.Fonts[base].sfnt.UnitsPerEm(); got != 1000 || other == got {
+		t.Fatalf("Expected a fallback of 1000 units per em beside %d, got %d", other, got)
+	}
+	fs.AddFallbackFont(base, fallback)
+
+	fs.SetSize(24.0)
+
+	fs.SetFont(fallback)
+	direct := fs.TextBounds(0, 0, string(missing), nil)
+
+	fs.SetFont(base)
+	viaFallback := fs.TextBounds(0, 0, string(missing), nil)
+
+	if viaFallback != direct {
+		t.Errorf("Expected fallback advance %f to match fallback font advance %f", viaFallback, direct)
+	}
+
+	face, err := opentype.NewFace(fs.Fonts[fallback].sfnt, &opentype.FaceOptions{Size: 24, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		t.Fatalf("Failed to create face: %v", err)
+	}
+	defer face.Close()
+	adv, _ := face.GlyphAdvance(missing)
+	if want := float32(adv.Round()); viaFallback != want {
+		t.Errorf("Expected advance %f, got %f", want, viaFallback)
+	}
+}
+
+func TestDrawSpacesEmitsNoVertices(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(24.0)
+
+	nodes := len(fs.Atlas.nodes)
+	x := fs.DrawText(10, 10, "    ")
+
+	if mock.Verts != 0 {
+		t.Errorf("Expected 0 verts for spaces, got %d", mock.Verts)
+	}
+	if x <= 10 {
+		t.Errorf("Expected spaces to advance the pen, got x=%f", x)
+	}
+	if len(fs.Atlas.nodes) != nodes {
+		t.Errorf("Expected spaces not to use atlas space")
+	}
+}
+
+func TestDirtyRectClampedToTexture(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 64, Height: 64, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20.0)
+
+	// Pack wide glyphs until they reach the right edge of the small atlas.
+	fs.DrawText(0, 0, "WWW")
+
+	// Simulate padding spilling past the texture.
+	fs.Dirty = image.Rect(40, 0, 80, 70)
+	fs.flush()
+
+	bounds := image.Rect(0, 0, fs.Width, fs.Height)
+	if len(mock.Rects) == 0 {
+		t.Fatalf("Expected atlas updates")
+	}
+	for _, r := range mock.Rects {
+		if !r.In(bounds) {
+			t.Errorf("Dirty rect %v exceeds texture bounds %v", r, bounds)
+		}
+	}
+}
+
+func TestBlurStaysInsideGlyphCell(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(32.0)
+	fs.SetBlur(6.0)
+
+	fs.DrawText(0, 0, "M")
+	first := fs.Fonts[fontNormal].Glyphs[0]
+	before := make([]byte, 0, int(first.X1-first.X0)*int(first.Y1-first.Y0))
+	for y := int(first.Y0); y < int(first.Y1); y++ {
+		before = append(before, fs.TexData[y*fs.Width+int(first.X0):y*fs.Width+int(first.X1)]...)
+	}
+
+	fs.DrawText(0, 0, "W")
+	second := fs.Fonts[fontNormal].Glyphs[1]
+	if second.X0 != first.X1 && second.Y0 != first.Y1 {
+		t.Fatalf("Expected glyphs to be packed adjacently, got %v and %v", first, second)
+	}
+
+	after := make([]byte, 0, len(before))
+	for y := int(first.Y0); y < int(first.Y1); y++ {
+		after = append(after, fs.TexData[y*fs.Width+int(first.X0):y*fs.Width+int(first.X1)]...)
+	}
+	if string(before) != string(after) {
+		t.Errorf("Blurring the second glyph modified the first glyph's cell")
+	}
+
+	// Nothing may be written outside the white rect and the two glyph cells.
+	cells := []image.Rectangle{
+		image.Rect(0, 0, whiteRectSize, whiteRectSize),
+		image.Rect(int(first.X0), int(first.Y0), int(first.X1), int(first.Y1)),
+		image.Rect(int(second.X0), int(second.Y0), int(second.X1), int(second.Y1)),
+	}
+	for y := 0; y < fs.Height; y++ {
+		for x := 0; x < fs.Width; x++ {
+			p := image.Pt(x, y)
+			inside := false
+			for _, c := range cells {
+				if p.In(c) {
+					inside = true
+				}
+			}
+			if !inside && fs.TexData[y*fs.Width+x] != 0 {
+				t.Fatalf("Pixel %v outside glyph cells is %d", p, fs.TexData[y*fs.Width+x])
+			}
+		}
+	}
+}
+
+func TestSubpixelPositioning(t *testing.T) {
+	for _, subpixel := range []bool{false, true} {
+		mock := &MockRenderer{}
+		fs, err := New(Params{Width: 512, Height: 512, Renderer: mock, Subpixel: subpixel})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(14.0)
+
+		fs.DrawText(10.0, 10, "o")
+		fs.DrawText(10.34, 10, "o")
+		fs.DrawText(10.67, 10, "o")
+
+		phases := map[int16]bool{}
+		for _, g := range fs.Fonts[fontNormal].Glyphs {
+			phases[g.Phase] = true
+		}
+		want := 1
+		if subpixel {
+			want = subpixelPhases
+		}
+		if len(phases) != want {
+			t.Errorf("Subpixel=%v: expected %d cached phases, got %d", subpixel, want, len(phases))
+		}
+	}
+}
+
+func TestGammaCoverage(t *testing.T) {
+	render := func(gamma float32) []byte {
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, Gamma: gamma})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(18.0)
+		fs.DrawText(0, 0, "Gamma")
+		return fs.TexData
+	}
+
+	linear := render(0)
+	if string(render(1.0)) != string(linear) {
+		t.Errorf("Expected gamma 1.0 to leave coverage unchanged")
+	}
+
+	corrected := render(2.2)
+	var sumLinear, sumCorrected int
+	for i := range linear {
+		sumLinear += int(linear[i])
+		sumCorrected += int(corrected[i])
+	}
+	if sumCorrected <= sumLinear {
+		t.Errorf("Expected gamma 2.2 to increase coverage, got %d <= %d", sumCorrected, sumLinear)
+	}
+}
+
+func TestDrawTextBytesMatchesString(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(24.0)
+	fs.SetAlign(AlignCenter | AlignBaseline)
+
+	s := "Héllo, wörld"
+	var want, got [4]float32
+	if a, b := fs.TextBounds(10, 10, s, &want), fs.TextBoundsBytes(10, 10, []byte(s), &got); a != b || want != got {
+		t.Errorf("TextBoundsBytes = %f %v, want %f %v", b, got, a, want)
+	}
+
+	x := fs.DrawText(10, 10, s)
+	verts := mock.Verts
+	if xb := fs.DrawTextBytes(10, 10, []byte(s)); xb != x {
+		t.Errorf("DrawTextBytes = %f, want %f", xb, x)
+	}
+	if mock.Verts != 2*verts {
+		t.Errorf("Expected %d verts from DrawTextBytes, got %d", verts, mock.Verts-verts)
+	}
+}
+
+func TestCaretPositions(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(24.0)
+
+	s := "AVé b"
+	carets := fs.CaretPositions(10, s)
+	if len(carets) != 6 {
+		t.Fatalf("Expected 6 carets, got %d", len(carets))
+	}
+	if carets[0] != 10 {
+		t.Errorf("Expected first caret at 10, got %f", carets[0])
+	}
+	if end := fs.DrawText(10, 10, s); carets[5] != end {
+		t.Errorf("Expected last caret %f to match DrawText %f", carets[5], end)
+	}
+	for i := 1; i < len(carets); i++ {
+		if carets[i] < carets[i-1] {
+			t.Errorf("Carets not monotonic: %v", carets)
+		}
+	}
+
+	if got := fs.IndexAtX(10, 0, s); got != 0 {
+		t.Errorf("IndexAtX before text = %d, want 0", got)
+	}
+	if got := fs.IndexAtX(10, 1000, s); got != 5 {
+		t.Errorf("IndexAtX after text = %d, want 5", got)
+	}
+	if got := fs.IndexAtX(10, carets[2]+0.5, s); got != 2 {
+		t.Errorf("IndexAtX near caret 2 = %d, want 2", got)
+	}
+
+	fs.SetAlign(AlignCenter | AlignBaseline)
+	width := fs.TextBounds(10, 10, s, nil)
+	centered := fs.CaretPositions(10, s)
+	if centered[0] != 10-width*0.5 {
+		t.Errorf("Expected centered first caret at %f, got %f", 10-width*0.5, centered[0])
+	}
+	if got := fs.IndexAtX(10, centered[3], s); got != 3 {
+		t.Errorf("IndexAtX with center align = %d, want 3", got)
+	}
+
+	// The caret before V sits where V is drawn, after the A-V kerning.
+	fs.SetAlign(AlignLeft | AlignBaseline)
+	kern := fs.GlyphAdvances([]rune("AV"), nil)[1] - fs.TextBounds(0, 0, "V", nil)
+	if kern == 0 {
+		t.Fatal("Expected the test font to kern A-V")
+	}
+	if got, want := fs.CaretPositions(10, "AV")[1], 10+fs.TextBounds(0, 0, "A", nil)+kern; got != want {
+		t.Errorf("Expected the caret before V at %v, got %v", want, got)
+	}
+}
+
+type recordingRenderer struct {
+	MockRenderer
+	Vertices []Vertex
+}
+
+func (r *recordingRenderer) Draw(verts []Vertex) {
+	r.MockRenderer.Draw(verts)
+	r.Vertices = append(r.Vertices, verts...)
+}
+
+func TestDrawTextFuncColors(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: rec})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(12.0)
+
+	// Long enough to force a flush part way through the string.
+	var s string
+	for range 40 {
+		s += "abcdef"
+	}
+
+	calls := 0
+	fs.DrawTextFunc(0, 0, s, func(i int, r rune) uint32 {
+		if i != calls {
+			t.Fatalf("Expected rune index %d, got %d", calls, i)
+		}
+		calls++
+		return uint32(r)
+	})
+
+	if calls != len(s) {
+		t.Errorf("Expected %d color calls, got %d", len(s), calls)
+	}
+	if rec.Draws < 2 {
+		t.Errorf("Expected multiple draw batches, got %d", rec.Draws)
+	}
+	if len(rec.Vertices) != len(s)*vertsPerQuad {
+		t.Fatalf("Expected %d verts, got %d", len(s)*vertsPerQuad, len(rec.Vertices))
+	}
+	for i, v := range rec.Vertices {
+		if want := uint32(s[i/vertsPerQuad]); v.Color != want {
+			t.Fatalf("Vertex %d color = %#x, want %#x", i, v.Color, want)
+		}
+	}
+}
+
+func TestVerticalLayout(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: mock, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(24.0)
+	fs.SetDirection(DirTTB)
+
+	s := "ABCD"
+	var bounds [4]float32
+	height := fs.TextBounds(100, 10, s, &bounds)
+	if height != 4*24 {
+		t.Errorf("Expected vertical advance %d, got %f", 4*24, height)
+	}
+	if w, h := bounds[2]-bounds[0], bounds[3]-bounds[1]; h <= w {
+		t.Errorf("Expected a tall narrow box, got %v", bounds)
+	}
+	if bounds[0] > 100 || bounds[2] < 100 {
+		t.Errorf("Expected column centered on x=100, got %v", bounds)
+	}
+
+	if y := fs.DrawText(100, 10, s); y != 10+height {
+		t.Errorf("Expected DrawText to return pen y %f, got %f", 10+height, y)
+	}
+	if mock.Verts != len(s)*vertsPerQuad {
+		t.Errorf("Expected %d verts, got %d", len(s)*vertsPerQuad, mock.Verts)
+	}
+}
+
+func TestCacheGlyphs(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(24.0)
+
+	if err := fs.CacheGlyphs("abc"); err != nil {
+		t.Fatalf("CacheGlyphs: %v", err)
+	}
+	if got := len(fs.Fonts[fontNormal].Glyphs); got != 3 {
+		t.Errorf("Expected 3 cached glyphs, got %d", got)
+	}
+	fs.DrawText(0, 0, "cab")
+	if got := len(fs.Fonts[fontNormal].Glyphs); got != 3 {
+		t.Errorf("Expected drawing to reuse cached glyphs, got %d", got)
+	}
+
+	small, err := New(Params{Width: 32, Height: 32, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	if _, err := small.AddFont("sans", "testdata/DroidSerif-Regular.ttf"); err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	small.SetSize(24.0)
+	if err := small.CacheGlyphs("ABCDEFGHIJ"); err != ErrAtlasFull {
+		t.Errorf("Expected ErrAtlasFull, got %v", err)
+	}
+}
+
+func TestCacheGlyphsSorted(t *testing.T) {
+	atlas := func(cache func(fs *FontStash, str string) error, str string) ([]byte, []byte) {
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(24)
+		if err := cache(fs, str); err != nil {
+			t.Fatalf("Caching %q: %v", str, err)
+		}
+		// Drawing afterwards adds nothing.
+		fs.DrawText(0, 0, str)
+		var buf bytes.Buffer
+		if err := fs.SaveAtlas(&buf); err != nil {
+			t.Fatalf("SaveAtlas: %v", err)
+		}
+		return fs.TexData, buf.Bytes()
+	}
+
+	tex, snap := atlas((*FontStash).CacheGlyphsSorted, "Wavy fog")
+	reversedTex, reversedSnap := atlas((*FontStash).CacheGlyphsSorted, "gof yvaWW")
+	if !bytes.Equal(tex, reversedTex) || !bytes.Equal(snap, reversedSnap) {
+		t.Errorf("Expected the same atlas and snapshot whatever the order")
+	}
+	if unsorted, _ := atlas((*FontStash).CacheGlyphs, "gof yvaW"); bytes.Equal(tex, unsorted) {
+		t.Errorf("Expected CacheGlyphs to pack in first-seen order")
+	}
+}
+
+func TestCacheGlyphRange(t *testing.T) {
+	fs, err := New(Params{Width: 64, Height: 64, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(16)
+
+	if err := fs.CacheGlyphRange('A', 'Z'); err != ErrAtlasFull {
+		t.Fatalf("Expected ErrAtlasFull, got %v", err)
+	}
+
+	// Growing the atlas from the error callback lets the range finish.
+	fs.Params.ErrorCallback = func(err error) {
+		if err == ErrAtlasFull {
+			fs.ExpandAtlas(fs.Width*2, fs.Height*2)
+		}
+	}
+	// Hangul syllables are missing from the font and are skipped.
+	before := len(fs.Fonts[fontNormal].Glyphs)
+	if err := fs.CacheGlyphRange(0xAC00, 0xAC10); err != nil || len(fs.Fonts[fontNormal].Glyphs) != before {
+		t.Errorf("Expected uncovered codepoints to be skipped, got %v and %d glyphs", err, len(fs.Fonts[fontNormal].Glyphs)-before)
+	}
+	if err := fs.CacheGlyphRange('A', 'Z'); err != nil {
+		t.Fatalf("CacheGlyphRange: %v", err)
+	}
+	for r := 'A'; r <= 'Z'; r++ {
+		if _, g := fs.Fonts[fontNormal].findGlyph(r, 160, 0, 0); g == nil {
+			t.Errorf("Expected %q to be cached", r)
+		}
+	}
+	if fs.Width == 64 {
+		t.Errorf("Expected the atlas to have been expanded")
+	}
+}
+
+func TestRemoveFont(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	base, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fallback, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load fallback font: %v", err)
+	}
+	fs.AddFallbackFont(base, fallback)
+	fs.SetFont(base)
+	fs.SetSize(24.0)
+	fs.DrawText(0, 0, "a→")
+
+	if err := fs.RemoveFont(fallback); err != nil {
+		t.Fatalf("RemoveFont: %v", err)
+	}
+	if err := fs.RemoveFont(fallback); err != ErrInvalidFont {
+		t.Errorf("Expected ErrInvalidFont removing twice, got %v", err)
+	}
+	if len(fs.Fonts[base].Fallbacks) != 0 {
+		t.Errorf("Expected fallback to be unlinked, got %v", fs.Fonts[base].Fallbacks)
+	}
+	for _, g := range fs.Fonts[base].Glyphs {
+		if g.Font == fallback {
+			t.Errorf("Expected glyphs rendered from the removed font to be dropped")
+		}
+	}
+	if _, err := fs.getGlyph(fs.Fonts[base], 'a', 240, 0, 0); err != nil || len(fs.Fonts[base].Glyphs) != 1 {
+		t.Errorf("Expected base font glyphs to survive, got %d", len(fs.Fonts[base].Glyphs))
+	}
+
+	// Drawing with a state that still refers to the removed handle is a no-op.
+	mock.Verts = 0
+	fs.getState().Font = fallback
+	if x := fs.DrawText(5, 0, "abc"); x != 5 || mock.Verts != 0 {
+		t.Errorf("Expected no output for a removed font, got x=%f verts=%d", x, mock.Verts)
+	}
+	if w := fs.TextBounds(0, 0, "abc", nil); w != 0 {
+		t.Errorf("Expected zero width for a removed font, got %f", w)
+	}
+
+	// The freed slot is reused.
+	again, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to reload font: %v", err)
+	}
+	if again != fallback {
+		t.Errorf("Expected handle %d to be reused, got %d", fallback, again)
+	}
+}
+
+func TestSetFontRejectsInvalidHandle(t *testing.T) {
+	var reported error
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}, ErrorCallback: func(err error) { reported = err }})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	if !fs.SetFont(fontNormal) {
+		t.Fatalf("Expected SetFont(%d) to succeed", fontNormal)
+	}
+	if fs.SetFont(999) {
+		t.Errorf("Expected SetFont(999) to be rejected")
+	}
+	if reported != ErrInvalidFont {
+		t.Errorf("Expected ErrInvalidFont to be reported, got %v", reported)
+	}
+	if fs.getState().Font != fontNormal {
+		t.Errorf("Expected state font to stay %d, got %d", fontNormal, fs.getState().Font)
+	}
+}
+
+func TestWhiteRectSurvivesExpand(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 128, Height: 128, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.DrawText(0, 0, "abc")
+
+	texel := func() byte {
+		u, v := fs.WhiteRectUV()
+		return fs.TexData[int(v*float32(fs.Height))*fs.Width+int(u*float32(fs.Width))]
+	}
+	if got := texel(); got != 0xff {
+		t.Fatalf("Expected white texel before expand, got %d", got)
+	}
+
+	fs.ExpandAtlas(256, 512)
+	if got := texel(); got != 0xff {
+		t.Errorf("Expected white texel after expand, got %d", got)
+	}
+
+	fs.ResetAtlas(64, 64)
+	if got := texel(); got != 0xff {
+		t.Errorf("Expected white texel after reset, got %d", got)
+	}
+}
+
+func TestDrawRect(t *testing.T) {
+	for _, flags := range []int{ZeroTopLeft, ZeroBottomLeft} {
+		rec := &recordingRenderer{}
+		fs, err := New(Params{Width: 128, Height: 128, Renderer: rec, Flags: flags})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+
+		fs.DrawRect(10, 40, 50, 20, 0xff0000ff)
+		if rec.Draws != 0 {
+			t.Fatalf("Expected DrawRect to be batched, got %d draws", rec.Draws)
+		}
+		fs.DrawText(0, 0, "a")
+		if rec.Draws != 1 || len(rec.Vertices) != 2*vertsPerQuad {
+			t.Fatalf("Expected rect and text in one batch, got %d draws and %d verts", rec.Draws, len(rec.Vertices))
+		}
+
+		u, v := fs.WhiteRectUV()
+		top, bottom := float32(20), float32(40)
+		if flags == ZeroBottomLeft {
+			top, bottom = bottom, top
+		}
+		rect := rec.Vertices[:vertsPerQuad]
+		if rect[0].X != 10 || rect[0].Y != top || rect[1].X != 50 || rect[1].Y != bottom {
+			t.Errorf("Flags %d: unexpected rect corners %v", flags, rect)
+		}
+		for _, vert := range rect {
+			if vert.U != u || vert.V != v || vert.Color != 0xff0000ff {
+				t.Errorf("Flags %d: unexpected rect vertex %+v", flags, vert)
+			}
+		}
+	}
+}
+
+func TestNoWhiteRect(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 128, Height: 128, Renderer: rec, NoWhiteRect: true})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	if len(fs.Atlas.nodes) != 1 || fs.Atlas.nodes[0].y != 0 {
+		t.Fatalf("Expected an empty atlas, got nodes %v", fs.Atlas.nodes)
+	}
+	if u, v := fs.WhiteRectUV(); u != 0 || v != 0 {
+		t.Errorf("Expected no white rect coordinates, got %v, %v", u, v)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetDecoration(DecorationUnderline)
+
+	fs.DrawRect(10, 20, 50, 40, 0xff0000ff)
+	fs.DrawText(0, 30, "a")
+	if len(rec.Vertices) != vertsPerQuad {
+		t.Errorf("Expected only the glyph quad, got %d verts", len(rec.Vertices))
+	}
+
+	fs.ResetAtlas(64, 64)
+	if len(fs.Atlas.nodes) != 1 || fs.Atlas.nodes[0].y != 0 {
+		t.Errorf("Expected ResetAtlas to leave the atlas empty, got nodes %v", fs.Atlas.nodes)
+	}
+}
+
+func TestWhiteRectSize(t *testing.T) {
+	fs, err := New(Params{Width: 128, Height: 128, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	if fs.Params.WhiteRectSize != 2 || fs.whiteRect != image.Rect(0, 0, 2, 2) {
+		t.Errorf("Expected a 2x2 white rect by default, got %d and %v", fs.Params.WhiteRectSize, fs.whiteRect)
+	}
+
+	fs, err = New(Params{Width: 128, Height: 128, Renderer: &MockRenderer{}, WhiteRectSize: 8})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	for _, size := range []int{128, 64} {
+		if fs.whiteRect != image.Rect(0, 0, 8, 8) {
+			t.Fatalf("Atlas %d: expected an 8x8 white rect, got %v", size, fs.whiteRect)
+		}
+		for y := range 8 {
+			for x := range 8 {
+				if a := fs.TexData[y*fs.Width+x]; a != 0xff {
+					t.Fatalf("Atlas %d: expected solid white at %d,%d, got %#x", size, x, y, a)
+				}
+			}
+		}
+		if u, v := fs.WhiteRectUV(); u != 4/float32(size) || v != 4/float32(size) {
+			t.Errorf("Atlas %d: expected the center of the white rect, got %v, %v", size, u, v)
+		}
+		fs.ResetAtlas(64, 64)
+	}
+}
+
+func TestGlyphPadding(t *testing.T) {
+	cell := func(padding int) (int, int) {
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, GlyphPadding: padding})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(24)
+		fs.SetBlur(2)
+		fs.DrawText(0, 0, "x")
+		g := fs.Fonts[fontNormal].Glyphs[0]
+		return int(g.X1 - g.X0), int(g.XOff)
+	}
+
+	defW, defOff := cell(0)
+	if w, off := cell(2); w != defW || off != defOff {
+		t.Errorf("Expected default padding of 2, got width %d vs %d", w, defW)
+	}
+	w, off := cell(5)
+	if w != defW+6 {
+		t.Errorf("Expected cell width %d with padding 5, got %d", defW+6, w)
+	}
+	if off != defOff-3 {
+		t.Errorf("Expected glyph offset %d with padding 5, got %d", defOff-3, off)
+	}
+}
+
+func TestDPIKeepsLayout(t *testing.T) {
+	measure := func(dpi float64) (float32, int) {
+		fs, err := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}, DPI: dpi})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(16)
+		width := fs.TextBounds(0, 0, "Hello HiDPI", nil)
+		var h int
+		for _, g := range fs.Fonts[fontNormal].Glyphs {
+			if g.Codepoint == 'H' {
+				h = int(g.Y1 - g.Y0)
+			}
+		}
+		return width, h
+	}
+
+	w72, h72 := measure(0)
+	w144, h144 := measure(144)
+	// Hinting and per-glyph rounding differ between densities, so allow a
+	// small drift rather than an exact match.
+	if d := w144 - w72; d < -0.05*w72 || d > 0.05*w72 {
+		t.Errorf("Expected layout width to stay about %f at 144 DPI, got %f", w72, w144)
+	}
+	if h144 < 2*h72-2*2*blurPadding {
+		t.Errorf("Expected glyphs rasterized at 2x density, got cell heights %d and %d", h72, h144)
+	}
+}
+
+func TestFallbackOrdering(t *testing.T) {
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	base, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	regular, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	mono, err := fs.AddFontFromBytes("gomono", gomono.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	if got := fs.ResolveGlyphFont(base, 'a'); got != base {
+		t.Errorf("Expected base font to supply 'a', got %d", got)
+	}
+	if got := fs.ResolveGlyphFont(base, '→'); got != -1 {
+		t.Errorf("Expected no font to supply '→' yet, got %d", got)
+	}
+
+	fs.AddFallbackFont(base, regular)
+	if got := fs.ResolveGlyphFont(base, '→'); got != regular {
+		t.Errorf("Expected fallback %d to supply '→', got %d", regular, got)
+	}
+
+	if !fs.InsertFallbackFont(base, mono, 0) {
+		t.Fatalf("InsertFallbackFont failed")
+	}
+	if got := fs.Fonts[base].Fallbacks; len(got) != 2 || got[0] != mono || got[1] != regular {
+		t.Errorf("Unexpected fallback order %v", got)
+	}
+	if got := fs.ResolveGlyphFont(base, '→'); got != mono {
+		t.Errorf("Expected inserted fallback %d to take priority, got %d", mono, got)
+	}
+	if fs.InsertFallbackFont(base, 99, 0) {
+		t.Errorf("Expected InsertFallbackFont to reject an invalid handle")
+	}
+
+	fs.ClearFallbacks(base)
+	if got := fs.ResolveGlyphFont(base, '→'); got != -1 {
+		t.Errorf("Expected no fallback after ClearFallbacks, got %d", got)
+	}
+}
+
+func TestDecorations(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: rec, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+	fs.SetColor(0xff00ff00)
+	fs.SetDecoration(DecorationUnderline | DecorationStrikethrough)
+
+	end := fs.DrawText(10, 50, "abc")
+	if len(rec.Vertices) != 5*vertsPerQuad {
+		t.Fatalf("Expected 3 glyphs and 2 lines, got %d verts", len(rec.Vertices))
+	}
+
+	u, v := fs.WhiteRectUV()
+	underline := rec.Vertices[3*vertsPerQuad : 4*vertsPerQuad]
+	strike := rec.Vertices[4*vertsPerQuad:]
+	for _, vert := range append(underline, strike...) {
+		if vert.U != u || vert.V != v || vert.Color != 0xff00ff00 {
+			t.Fatalf("Unexpected decoration vertex %+v", vert)
+		}
+	}
+	if underline[0].X != 10 || underline[1].X != end {
+		t.Errorf("Expected underline to span 10 to %f, got %f to %f", end, underline[0].X, underline[1].X)
+	}
+	if underline[0].Y <= 50 {
+		t.Errorf("Expected underline below the baseline, got top %f", underline[0].Y)
+	}
+	if strike[1].Y >= 50 {
+		t.Errorf("Expected strikethrough above the baseline, got bottom %f", strike[1].Y)
+	}
+
+	rec.Vertices = nil
+	fs.SetDecoration(0)
+	fs.DrawText(10, 50, "abc")
+	if len(rec.Vertices) != 3*vertsPerQuad {
+		t.Errorf("Expected no decoration quads, got %d verts", len(rec.Vertices))
+	}
+}
+
+func TestUnderlineMetrics(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: rec, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	if pos, thick := fs.UnderlineMetrics(99, 40); pos != 0 || thick != 0 {
+		t.Errorf("Expected zero metrics for an invalid handle, got %v, %v", pos, thick)
+	}
+
+	// The post table values scale with the ascender to descender height,
+	// as sizes do.
+	post := fs.Fonts[fontNormal].sfnt.PostTable()
+	_, ascent, descent, _ := fs.FontMetrics(fontNormal)
+	pos, thick := fs.UnderlineMetrics(fontNormal, 40)
+	wantPos := float32(post.UnderlinePosition) * 40 / float32(ascent-descent)
+	wantThick := float32(post.UnderlineThickness) * 40 / float32(ascent-descent)
+	if math.Abs(float64(pos-wantPos)) > 0.05 || math.Abs(float64(thick-wantThick)) > 0.05 {
+		t.Errorf("Expected the post table's %v, %v, got %v, %v", wantPos, wantThick, pos, thick)
+	}
+
+	// They are where the underline is drawn.
+	fs.SetFont(fontNormal)
+	fs.SetSize(40)
+	fs.SetDecoration(DecorationUnderline)
+	fs.DrawText(10, 100, "a")
+	line := rec.Vertices[vertsPerQuad:]
+	if math.Abs(float64(line[0].Y-(100-pos))) > 0.01 || math.Abs(float64(line[1].Y-line[0].Y-thick)) > 0.01 {
+		t.Errorf("Expected an underline from %v, %v thick, got %v to %v", 100-pos, thick, line[0].Y, line[1].Y)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: rec, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	var plain, moved [4]float32
+	advance := fs.TextBounds(10, 50, "Hello", &plain)
+
+	// Rotate 90 degrees clockwise on screen, then translate.
+	fs.SetTransform([6]float32{0, 1, -1, 0, 100, 0})
+	if got := fs.TextBounds(10, 50, "Hello", &moved); got != advance {
+		t.Errorf("Expected untransformed advance %f, got %f", advance, got)
+	}
+	want := [4]float32{100 - plain[3], plain[0], 100 - plain[1], plain[2]}
+	if moved != want {
+		t.Errorf("Expected transformed bounds %v, got %v", want, moved)
+	}
+
+	fs.DrawRect(0, 0, 10, 20, 0xffffffff)
+	fs.Flush()
+	if v := rec.Vertices[1]; v.X != 80 || v.Y != 10 {
+		t.Errorf("Expected corner 10,20 to map to 80,10, got %f,%f", v.X, v.Y)
+	}
+
+	fs.PushState()
+	fs.ClearState()
+	rec.Vertices = nil
+	fs.DrawRect(0, 0, 10, 20, 0xffffffff)
+	fs.Flush()
+	if v := rec.Vertices[1]; v.X != 10 || v.Y != 20 {
+		t.Errorf("Expected ClearState to restore the identity, got %f,%f", v.X, v.Y)
+	}
+	fs.PopState()
+}
+
+func TestClipRect(t *testing.T) {
+	for _, flags := range []int{ZeroTopLeft, ZeroBottomLeft} {
+		rec := &recordingRenderer{}
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: rec, Flags: flags})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(40)
+
+		fs.DrawText(10, 100, "H")
+		full := append([]Vertex(nil), rec.Vertices...)
+		rec.Vertices = nil
+
+		midX := (full[0].X + full[1].X) * 0.5
+		fs.SetClipRect(midX, 0, 256, 256)
+		fs.DrawText(10, 100, "H")
+		if len(rec.Vertices) != vertsPerQuad {
+			t.Fatalf("Flags %d: expected one clipped quad, got %d verts", flags, len(rec.Vertices))
+		}
+		clipped := rec.Vertices
+		if clipped[0].X != midX || clipped[1].X != full[1].X {
+			t.Errorf("Flags %d: expected quad trimmed to %f..%f, got %f..%f", flags, midX, full[1].X, clipped[0].X, clipped[1].X)
+		}
+		wantU := (full[0].U + full[1].U) * 0.5
+		if d := clipped[0].U - wantU; d < -1e-6 || d > 1e-6 {
+			t.Errorf("Flags %d: expected left u %f, got %f", flags, wantU, clipped[0].U)
+		}
+		if clipped[0].Y != full[0].Y || clipped[0].V != full[0].V {
+			t.Errorf("Flags %d: expected y unchanged, got %+v", flags, clipped[0])
+		}
+
+		rec.Vertices = nil
+		fs.SetClipRect(200, 0, 256, 256)
+		fs.DrawText(10, 100, "H")
+		fs.DrawRect(0, 0, 50, 50, 0xffffffff)
+		fs.Flush()
+		if len(rec.Vertices) != 0 {
+			t.Errorf("Flags %d: expected quads outside the clip to be dropped, got %d verts", flags, len(rec.Vertices))
+		}
+
+		fs.SetClipRect(0, 0, 0, 0)
+		fs.DrawText(10, 100, "H")
+		if len(rec.Vertices) != vertsPerQuad || rec.Vertices[0] != full[0] {
+			t.Errorf("Flags %d: expected an empty clip to draw unclipped", flags)
+		}
+	}
+}
+
+func TestTextMetrics(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	m := fs.TextMetrics("ag")
+	if want := fs.TextBounds(0, 0, "ag", nil); m.Advance != want {
+		t.Errorf("Expected advance %f, got %f", want, m.Advance)
+	}
+	if m.InkMinY >= 0 || m.InkMaxY <= 0 {
+		t.Errorf("Expected ink to straddle the baseline, got %f..%f", m.InkMinY, m.InkMaxY)
+	}
+	if m.InkMinY < -m.LineAscent || m.LineDescent >= 0 {
+		t.Errorf("Unexpected line extents %f, %f for ink %f..%f", m.LineAscent, m.LineDescent, m.InkMinY, m.InkMaxY)
+	}
+
+	space := fs.TextMetrics("  ")
+	if space.Advance <= 0 || space.InkMinX != 0 || space.InkMaxX != 0 {
+		t.Errorf("Expected spaces to advance with no ink, got %+v", space)
+	}
+}
+
+func TestGlyphAdvances(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: mock})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+	fs.SetSpacing(1.5)
+
+	str := "AVATAR ok"
+	out := fs.GlyphAdvances([]rune(str), make([]float32, 2))
+	if len(out) != len(str) {
+		t.Fatalf("Expected %d advances, got %d", len(str), len(out))
+	}
+	var sum float32
+	for _, adv := range out {
+		sum += adv
+	}
+	if want := fs.TextBounds(0, 0, str, nil); sum != want {
+		t.Errorf("Expected advances to sum to %f, got %f", want, sum)
+	}
+	if mock.Verts != 0 {
+		t.Errorf("Expected no vertices, got %d", mock.Verts)
+	}
+}
+
+func TestSetHinting(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	_, hinted, err := fs.GlyphBitmap(fontNormal, 'W', 13, 0)
+	if err != nil {
+		t.Fatalf("GlyphBitmap: %v", err)
+	}
+	if hinted.XAdv%sizeScale != 0 {
+		t.Errorf("Expected a whole pixel advance with full hinting, got %d", hinted.XAdv)
+	}
+
+	if err := fs.SetHinting(fontNormal, font.HintingNone); err != nil {
+		t.Fatalf("SetHinting: %v", err)
+	}
+	if n := len(fs.Fonts[fontNormal].Glyphs); n != 0 {
+		t.Fatalf("Expected cached glyphs to be dropped, have %d", n)
+	}
+	_, unhinted, err := fs.GlyphBitmap(fontNormal, 'W', 13, 0)
+	if err != nil {
+		t.Fatalf("GlyphBitmap: %v", err)
+	}
+	if unhinted.XAdv == hinted.XAdv {
+		t.Errorf("Expected an unrounded advance without hinting, got %d", unhinted.XAdv)
+	}
+
+	if err := fs.SetHinting(42, font.HintingNone); err != ErrInvalidFont {
+		t.Errorf("Expected ErrInvalidFont, got %v", err)
+	}
+}
+
+func TestMaxVertices(t *testing.T) {
+	batches := func(maxVerts int) (int, int) {
+		rec := &batchRenderer{}
+		fs, err := New(Params{Width: 512, Height: 512, Renderer: rec, MaxVertices: maxVerts})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.DrawText(0, 0, "abcdefghijklmnopqrstuvwxyzabcdefghijklmn")
+		return rec.Draws, rec.largest
+	}
+
+	if draws, largest := batches(100); draws != 3 || largest != 96 {
+		t.Errorf("Expected 3 batches of at most 96 verts, got %d of up to %d", draws, largest)
+	}
+	if draws, _ := batches(0); draws != 1 {
+		t.Errorf("Expected the default to fit 40 glyphs in one batch, got %d", draws)
+	}
+	if draws, largest := batches(1); draws != 40 || largest != vertsPerQuad {
+		t.Errorf("Expected one quad per batch, got %d of up to %d", draws, largest)
+	}
+}
+
+type batchRenderer struct {
+	MockRenderer
+	largest int
+}
+
+func (r *batchRenderer) Draw(verts []Vertex) {
+	r.MockRenderer.Draw(verts)
+	r.largest = max(r.largest, len(verts))
+}
+
+func TestCacheCounters(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+
+	fs.DrawText(0, 0, "abca")
+	if hits, misses := fs.CacheCounters(); hits != 1 || misses != 3 {
+		t.Errorf("Expected 1 hit and 3 misses, got %d and %d", hits, misses)
+	}
+	fs.ResetCacheCounters()
+	fs.DrawText(0, 0, "abc")
+	if hits, misses := fs.CacheCounters(); hits != 3 || misses != 0 {
+		t.Errorf("Expected 3 hits after reset, got %d and %d", hits, misses)
+	}
+}
+
+func TestSpacingEm(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, Subpixel: true})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	plain := fs.TextBounds(0, 0, "abcd", nil)
+	fs.SetSpacingEm(0.1)
+	fs.SetSpacing(1)
+	// Three gaps, each 1px plus a tenth of 20px.
+	if got, want := fs.TextBounds(0, 0, "abcd", nil), plain+9; got < want-0.01 || got > want+0.01 {
+		t.Errorf("Expected width %f, got %f", want, got)
+	}
+	carets := fs.CaretPositions(0, "abcd")
+	if end := carets[len(carets)-1]; end < plain+8.99 || end > plain+9.01 {
+		t.Errorf("Expected carets to include spacing, got end %f", end)
+	}
+}
+
+func TestSnapBaseline(t *testing.T) {
+	quadTop := func(snap bool, y float32, transform bool) float32 {
+		rec := &recordingRenderer{}
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: rec, Flags: ZeroTopLeft, SnapBaseline: snap})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(20)
+		if transform {
+			fs.SetTransform([6]float32{1, 0, 0, 1, 0, 0.25})
+		}
+		fs.DrawText(10, y, "H")
+		return rec.Vertices[0].Y
+	}
+
+	// Truncation alone places y = 49.9 a pixel above y = 50.
+	if quadTop(false, 49.9, false) == quadTop(false, 50, false) {
+		t.Fatalf("Expected unsnapped baselines to differ")
+	}
+	if got, want := quadTop(true, 49.9, false), quadTop(true, 50, false); got != want {
+		t.Errorf("Expected snapped baseline to match y = 50, got %f and %f", got, want)
+	}
+	if quadTop(true, 49.9, true) != quadTop(false, 49.9, true) {
+		t.Errorf("Expected snapping to be skipped under a transform")
+	}
+}
+
+type failingResizer struct {
+	MockRenderer
+	fail bool
+}
+
+func (r *failingResizer) TryResize(width, height int) error {
+	if r.fail {
+		return errors.New("out of texture memory")
+	}
+	return nil
+}
+
+func TestExpandAtlasRefusal(t *testing.T) {
+	rec := &failingResizer{}
+	fs, err := New(Params{Width: 64, Height: 64, Renderer: rec, MaxAtlasSize: 256})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	texData := fs.TexData
+
+	if fs.ExpandAtlas(512, 64) {
+		t.Errorf("Expected expansion past MaxAtlasSize to fail")
+	}
+	rec.fail = true
+	if fs.ExpandAtlas(128, 128) {
+		t.Errorf("Expected expansion to fail when the renderer can't resize")
+	}
+	if fs.ResetAtlas(32, 32) {
+		t.Errorf("Expected reset to fail when the renderer can't resize")
+	}
+	if fs.Width != 64 || fs.Height != 64 || fs.Atlas.width != 64 || &fs.TexData[0] != &texData[0] {
+		t.Errorf("Expected atlas to be unchanged, got %dx%d", fs.Width, fs.Height)
+	}
+
+	rec.fail = false
+	if !fs.ExpandAtlas(32, 128) || fs.Width != 64 || fs.Height != 128 {
+		t.Errorf("Expected expansion to keep the larger width, got %dx%d", fs.Width, fs.Height)
+	}
+	if !fs.ResetAtlas(32, 32) || fs.Width != 32 {
+		t.Errorf("Expected ResetAtlas to shrink, got %dx%d", fs.Width, fs.Height)
+	}
+	if fs.ResetAtlas(0, 32) {
+		t.Errorf("Expected ResetAtlas to reject an empty size")
+	}
+}
+
+func TestAtlasChangedCallback(t *testing.T) {
+	type change struct {
+		width, height int
+		reason        AtlasChangeReason
+	}
+	var changes []change
+	fs, err := New(Params{
+		Width:        64,
+		Height:       64,
+		Renderer:     &MockRenderer{},
+		MaxAtlasSize: 256,
+		AtlasChangedCallback: func(width, height int, reason AtlasChangeReason) {
+			changes = append(changes, change{width, height, reason})
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+
+	fs.ExpandAtlas(128, 64)
+	fs.ExpandAtlas(32, 32) // Already larger
+	fs.ExpandAtlas(512, 512)
+	fs.ResetAtlas(32, 48)
+	fs.ResetAtlas(0, 0)
+	want := []change{{128, 64, AtlasExpanded}, {32, 48, AtlasReset}}
+	if !slices.Equal(changes, want) {
+		t.Errorf("Expected changes %v, got %v", want, changes)
+	}
+}
+
+func TestRendererPreservesOnResize(t *testing.T) {
+	for _, preserves := range []bool{false, true} {
+		mock := &MockRenderer{}
+		fs, err := New(Params{Width: 128, Height: 128, Renderer: mock, RendererPreservesOnResize: preserves})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		if _, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf"); err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetSize(24)
+		fs.DrawText(0, 0, "Resize")
+		maxy := 0
+		for _, n := range fs.Atlas.nodes {
+			maxy = max(maxy, int(n.y))
+		}
+
+		mock.Rects = nil
+		fs.ExpandAtlas(256, 256)
+		fs.DrawText(0, 0, "") // Flushes
+		if preserves {
+			if len(mock.Rects) != 0 {
+				t.Errorf("Expected nothing to be uploaded, got %v", mock.Rects)
+			}
+		} else if want := image.Rect(0, 0, 256, maxy); len(mock.Rects) != 1 || mock.Rects[0] != want {
+			t.Errorf("Expected %v to be uploaded, got %v", want, mock.Rects)
+		}
+
+		// Glyphs packed into the new region upload their own cells.
+		mock.Rects = nil
+		fs.SetSize(60)
+		fs.DrawText(0, 0, "Expanded")
+		if len(mock.Rects) != 1 || mock.Rects[0].Empty() {
+			t.Errorf("Preserves %v: expected the new glyphs to be uploaded, got %v", preserves, mock.Rects)
+		}
+	}
+}
+
+func TestBlurTinyRegions(t *testing.T) {
+	fs, err := New(Params{Width: 16, Height: 16, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	for _, size := range [][2]int{{0, 0}, {1, 1}, {1, 4}, {4, 1}, {0, 3}, {3, 0}, {2, 2}} {
+		for i := range fs.TexData {
+			fs.TexData[i] = 0x80
+		}
+		w, h := size[0], size[1]
+		fs.blur(fs.TexData, 0, 0, w, h, fs.Width, 3)
+
+		// Texels outside the region are untouched.
+		for y := 0; y < fs.Height; y++ {
+			for x := 0; x < fs.Width; x++ {
+				if (x >= w || y >= h) && fs.TexData[y*fs.Width+x] != 0x80 {
+					t.Fatalf("%dx%d blur wrote outside its region at %d,%d", w, h, x, y)
+				}
+			}
+		}
+	}
+}
+
+type rawRenderer struct {
+	MockRenderer
+	Pos   []float32
+	UV    []float32
+	Col   []uint32
+	Calls int
+}
+
+func (r *rawRenderer) DrawRaw(pos, uv []float32, col []uint32, n int) {
+	r.Calls++
+	r.Pos = append(r.Pos, pos[:n*2]...)
+	r.UV = append(r.UV, uv[:n*2]...)
+	r.Col = append(r.Col, col[:n]...)
+}
+
+func TestDrawRaw(t *testing.T) {
+	rec := &recordingRenderer{}
+	raw := &rawRenderer{}
+	for _, r := range []Renderer{rec, raw} {
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: r})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetColor(RGBA(1, 2, 3, 4))
+		fs.DrawText(10, 20, "Raw")
+	}
+
+	if raw.Calls != 1 || raw.Draws != 0 {
+		t.Fatalf("Expected one DrawRaw call and no Draw, got %d and %d", raw.Calls, raw.Draws)
+	}
+	if len(raw.Col) != len(rec.Vertices) {
+		t.Fatalf("Expected %d raw vertices, got %d", len(rec.Vertices), len(raw.Col))
+	}
+	for i, v := range rec.Vertices {
+		got := Vertex{X: raw.Pos[i*2], Y: raw.Pos[i*2+1], U: raw.UV[i*2], V: raw.UV[i*2+1], Color: raw.Col[i]}
+		if got != v {
+			t.Fatalf("Raw vertex %d = %+v, want %+v", i, got, v)
+		}
+	}
+}
+
+func TestBlurConservesEnergy(t *testing.T) {
+	for _, params := range []Params{{}, {BlurPasses: 4}, {GaussianBlur: true}} {
+		params.Width, params.Height, params.Renderer = 64, 64, &MockRenderer{}
+		fs, err := New(params)
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		// A solid block with room to spread inside a 40x40 region.
+		energy := func() (sum int) {
+			for _, p := range fs.TexData {
+				sum += int(p)
+			}
+			return sum
+		}
+		clear(fs.TexData)
+		for y := 14; y < 26; y++ {
+			for x := 14; x < 26; x++ {
+				fs.TexData[y*fs.Width+x] = 0xff
+			}
+		}
+		before := energy()
+		fs.blur(fs.TexData, 0, 0, 40, 40, fs.Width, 6)
+		after := energy()
+		if d := float64(after-before) / float64(before); d < -0.1 || d > 0.1 {
+			t.Errorf("%+v: expected blur to keep energy %d within 10%%, got %d", params, before, after)
+		}
+
+		// The spread is symmetric about the block's center.
+		for y := 0; y < 40; y++ {
+			for x := 0; x < 20; x++ {
+				l, r := int(fs.TexData[y*fs.Width+x]), int(fs.TexData[y*fs.Width+39-x])
+				if l-r > 16 || r-l > 16 {
+					t.Fatalf("%+v: asymmetric blur at %d,%d: %d vs %d", params, x, y, l, r)
+				}
+			}
+		}
+	}
+}
+
+func TestUnsetFlagsDefaultToTopLeft(t *testing.T) {
+	draw := func(flags int) (*FontStash, []Vertex) {
+		rec := &recordingRenderer{}
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: rec, Flags: flags})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(20)
+		fs.SetAlign(AlignLeft | AlignTop)
+		fs.DrawText(10, 50, "Ag")
+		return fs, rec.Vertices
+	}
+
+	_, topLeft := draw(ZeroTopLeft)
+	fs, unset := draw(0)
+	if len(unset) != len(topLeft) {
+		t.Fatalf("Expected %d verts, got %d", len(topLeft), len(unset))
+	}
+	for i := range unset {
+		if unset[i] != topLeft[i] {
+			t.Fatalf("Vertex %d = %+v, want %+v as with ZeroTopLeft", i, unset[i], topLeft[i])
+		}
+	}
+	if miny, maxy := fs.LineBounds(50); miny < 49.99 || miny > 50.01 || maxy <= 50 {
+		t.Errorf("Expected top aligned line box to start at 50 and grow down, got %f..%f", miny, maxy)
+	}
+
+	fs, bottomLeft := draw(ZeroBottomLeft)
+	if bottomLeft[0].Y > 50 || bottomLeft[1].Y >= 50 {
+		t.Errorf("Expected top aligned text below y = 50 with y up, got top %f", bottomLeft[0].Y)
+	}
+	if miny, maxy := fs.LineBounds(50); maxy < 49.99 || maxy > 50.01 || miny >= 50 {
+		t.Errorf("Expected top aligned line box to end at 50 and grow up, got %f..%f", miny, maxy)
+	}
+}
+
+type textureRenderer struct {
+	MockRenderer
+	Batches [][]Batch
+}
+
+func (r *textureRenderer) DrawBatch(batches []Batch) {
+	r.Batches = append(r.Batches, batches)
+}
+
+func TestDrawBatch(t *testing.T) {
+	batcher := &textureRenderer{}
+	rec := &recordingRenderer{}
+	for _, r := range []Renderer{batcher, rec} {
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: r})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(20)
+		fs.DrawRect(0, 0, 10, 10, 0xffffffff)
+		fs.DrawText(10, 40, "Batch")
+	}
+
+	if batcher.Draws != 0 || len(batcher.Batches) != 1 {
+		t.Fatalf("Expected one DrawBatch call in place of Draw, got %d batches and %d draws", len(batcher.Batches), batcher.Draws)
+	}
+	batches := batcher.Batches[0]
+	if len(batches) != 1 || batches[0].Texture != 0 {
+		t.Fatalf("Expected a single batch for texture 0, got %+v", batches)
+	}
+	if !slices.Equal(batches[0].Vertices, rec.Vertices) {
+		t.Errorf("Expected the batch to hold the vertices Draw gets")
+	}
+}
+
+type multiRenderer struct {
+	MockRenderer
+	Multi [][]image.Rectangle
+}
+
+func (r *multiRenderer) MultiUpdate(rects []image.Rectangle, data []byte, stride int) {
+	r.Multi = append(r.Multi, append([]image.Rectangle(nil), rects...))
+}
+
+func TestMultiUpdate(t *testing.T) {
+	rec := &multiRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: rec})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	fs.DrawText(0, 0, "abc")
+	if len(rec.Multi) != 1 || rec.Updates != 0 {
+		t.Fatalf("Expected one MultiUpdate and no Update, got %d and %d", len(rec.Multi), rec.Updates)
+	}
+	// The white rect plus one rect per glyph, none overlapping.
+	rects := rec.Multi[0]
+	if len(rects) != 4 {
+		t.Fatalf("Expected 4 rects, got %v", rects)
+	}
+	for i := range rects {
+		for j := i + 1; j < len(rects); j++ {
+			if rects[i].Overlaps(rects[j]) {
+				t.Errorf("Rects %v and %v overlap", rects[i], rects[j])
+			}
+		}
+	}
+
+	fs.DrawText(0, 0, "abc")
+	if len(rec.Multi) != 1 {
+		t.Errorf("Expected cached glyphs not to upload again")
+	}
+
+	fs.ExpandAtlas(512, 512)
+	fs.Flush()
+	if rec.Updates != 1 || len(rec.Multi) != 1 {
+		t.Errorf("Expected a single Update after expanding, got %d updates and %d multi", rec.Updates, len(rec.Multi))
+	}
+}
+
+func TestHasGlyph(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	base, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	regular, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	if !fs.HasGlyph(base, 'a') || fs.HasGlyph(base, '→') {
+		t.Errorf("Unexpected coverage before adding a fallback")
+	}
+	fs.AddFallbackFont(base, regular)
+	if !fs.HasGlyph(base, '→') || fs.HasGlyph(base, 'あ') {
+		t.Errorf("Expected the fallback to cover '→' only")
+	}
+	if fs.HasGlyph(42, 'a') {
+		t.Errorf("Expected an invalid handle to have no glyphs")
+	}
+	if n := len(fs.Fonts[base].Glyphs); n != 0 {
+		t.Errorf("Expected HasGlyph not to cache glyphs, have %d", n)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { fs.HasGlyph(base, '→') }); allocs != 0 {
+		t.Errorf("Expected HasGlyph not to allocate, got %f allocs", allocs)
+	}
+}
+
+func TestVerticalMetricSigns(t *testing.T) {
+	// A copy of Go Regular whose hhea descender is stored positive, as
+	// some fonts do against convention.
+	flipped := bytes.Clone(goregular.TTF)
+	be := binary.BigEndian
+	for i := range int(be.Uint16(flipped[4:])) {
+		rec := flipped[12+16*i:]
+		if string(rec[:4]) == "hhea" {
+			d := flipped[be.Uint32(rec[8:])+6:]
+			be.PutUint16(d, uint16(-int16(be.Uint16(d))))
+		}
+	}
+
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	serif, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	regular, err := fs.AddFontFromBytes("regular", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	positive, err := fs.AddFontFromBytes("positive", flipped)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	for _, handle := range []int{serif, regular, positive} {
+		f := fs.Fonts[handle]
+		if f.Ascender <= 0 || f.Descender >= 0 || f.LineHeight < f.Ascender-f.Descender {
+			t.Errorf("%s: unexpected ascender %v, descender %v, line height %v", f.Name, f.Ascender, f.Descender, f.LineHeight)
+		}
+		if _, ascent, descent, lineGap := fs.FontMetrics(handle); ascent <= 0 || descent >= 0 || lineGap < 0 {
+			t.Errorf("%s: unexpected design metrics %d, %d, %d", f.Name, ascent, descent, lineGap)
+		}
+	}
+
+	// The flipped descender reads as the original one, so alignment is
+	// unchanged.
+	want, got := fs.Fonts[regular], fs.Fonts[positive]
+	if got.Ascender != want.Ascender || got.Descender != want.Descender || got.LineHeight != want.LineHeight {
+		t.Errorf("Expected %v, %v, %v, got %v, %v, %v", want.Ascender, want.Descender, want.LineHeight, got.Ascender, got.Descender, got.LineHeight)
+	}
+	fs.SetSize(20)
+	for _, align := range []int{AlignTop, AlignMiddle, AlignBottom} {
+		fs.SetAlign(AlignLeft | align)
+		fs.SetFont(regular)
+		wantY := fs.getVertAlign(want, align, 200)
+		fs.SetFont(positive)
+		if y := fs.getVertAlign(got, align, 200); y != wantY {
+			t.Errorf("Align %d: expected offset %v, got %v", align, wantY, y)
+		}
+	}
+}
+
+func TestFontMetrics(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	regular, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	upem, ascent, descent, lineGap := fs.FontMetrics(regular)
+	if upem != 2048 || ascent != 1935 || descent != -432 || lineGap != 0 {
+		t.Errorf("Unexpected design metrics %d %d %d %d", upem, ascent, descent, lineGap)
+	}
+	// The normalized metrics are the same ratios.
+	f := fs.Fonts[regular]
+	if want := float32(ascent) / float32(ascent-descent); math.Abs(float64(f.Ascender-want)) > 1e-4 {
+		t.Errorf("Ascender %v does not match design ascent ratio %v", f.Ascender, want)
+	}
+	if upem, _, _, _ := fs.FontMetrics(42); upem != 0 {
+		t.Errorf("Expected zero metrics for an invalid handle")
+	}
+}
+
+func TestSetSizePt(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+
+	fs.SetSizePt(12, 96)
+	if size := fs.getState().Size; size != 16 {
+		t.Errorf("Expected 12pt at 96dpi to be 16px, got %v", size)
+	}
+	pt := fs.DrawText(0, 20, "Hello")
+	fs.SetSize(16)
+	if px := fs.DrawText(0, 20, "Hello"); px != pt {
+		t.Errorf("Expected 12pt at 96dpi to advance like 16px, got %v and %v", pt, px)
+	}
+	if n := len(fs.Fonts[fontNormal].Glyphs); n != 4 {
+		t.Errorf("Expected both sizes to share 4 cached glyphs, have %d", n)
+	}
+}
+
+func TestLargeGlyphFields(t *testing.T) {
+	fs, err := New(Params{Width: 4096, Height: 512, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+
+	// Push the next glyph against the right edge of the atlas.
+	if _, _, ok := fs.ReserveRect(3600, 8); !ok {
+		t.Fatalf("ReserveRect failed")
+	}
+	fs.SetSize(400)
+	fs.DrawText(0, 400, "W")
+	g := fs.Fonts[fontNormal].Glyphs[0]
+	if g.X0 < 3600 || g.X1 <= g.X0 || g.X1 > 4096 || g.XAdv <= 0 {
+		t.Errorf("Glyph placed at %d..%d with advance %d", g.X0, g.X1, g.XAdv)
+	}
+
+	// At 3000px the per mille sign advances 3870px, which is past the
+	// range of an int16 in tenths of a pixel.
+	m, ok := fs.measureGlyph(fs.Fonts[fontNormal], '‰', 30000, 0, 0)
+	if !ok || m.XAdv < 38000 || m.XAdv > 39000 {
+		t.Errorf("Expected an advance of about 38700, got %d", m.XAdv)
+	}
+}
+
+func TestMeasureOnly(t *testing.T) {
+	ref, err := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	m, err := NewMeasurer(Params{Width: 512, Height: 512})
+	if err != nil {
+		t.Fatalf("Failed to create measurer: %v", err)
+	}
+	if m.TexData != nil {
+		t.Errorf("Expected no atlas texture, have %d bytes", len(m.TexData))
+	}
+
+	for _, fs := range []*FontStash{ref, m} {
+		font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(font)
+		fs.SetSize(24)
+		fs.SetBlur(2)
+		fs.SetAlign(AlignCenter | AlignMiddle)
+	}
+
+	const str = "Hello, gjq World!"
+	var want, got [4]float32
+	wantAdv := ref.TextBounds(10, 40, str, &want)
+	gotAdv := m.TextBounds(10, 40, str, &got)
+	if gotAdv != wantAdv || got != want {
+		t.Errorf("Measured %v %v, want %v %v", gotAdv, got, wantAdv, want)
+	}
+
+	// Drawing lays out but has nothing to upload or draw.
+	if x := m.DrawText(10, 40, str); x != ref.DrawText(10, 40, str) {
+		t.Errorf("Expected DrawText to advance as when drawing")
+	}
+	if _, _, err := m.GlyphBitmap(0, 'A', 24, 0); err != ErrMeasureOnly {
+		t.Errorf("Expected ErrMeasureOnly from GlyphBitmap, got %v", err)
+	}
+	if err := m.WriteRegion(0, 0, 1, 1, []byte{1}); err != ErrMeasureOnly {
+		t.Errorf("Expected ErrMeasureOnly from WriteRegion, got %v", err)
+	}
+	if !m.ExpandAtlas(1024, 1024) || !m.ResetAtlas(256, 256) || m.TexData != nil {
+		t.Errorf("Expected atlas resizes to succeed without allocating")
+	}
+}
+
+func TestNoAdvanceDrift(t *testing.T) {
+	stashes := make([]*FontStash, 2)
+	for i, subpixel := range []bool{false, true} {
+		fs, err := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}, Subpixel: subpixel})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(font)
+		fs.SetSize(13)
+		fs.SetSpacing(-0.3)
+		stashes[i] = fs
+	}
+
+	var str string
+	for range 20 {
+		str += "Wavy text "
+	}
+	whole := stashes[0].TextBounds(0, 0, str, nil)
+	carets := stashes[0].CaretPositions(0, str)
+	if len(carets) != 201 {
+		t.Fatalf("Expected 201 carets, got %d", len(carets))
+	}
+	if sum := carets[200] - carets[0]; math.Abs(float64(sum-whole)) > 1e-3 {
+		t.Errorf("Caret span %v disagrees with TextBounds %v", sum, whole)
+	}
+	// Without per-glyph rounding, pixel-snapped and subpixel layouts
+	// advance the same distance over a long line.
+	if sub := stashes[1].TextBounds(0, 0, str, nil); math.Abs(float64(sub-whole)) > 1e-2 {
+		t.Errorf("Pixel-snapped width %v drifted from subpixel width %v", whole, sub)
+	}
+}
+
+func TestReset(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: rec})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(font)
+	fs.DrawText(0, 20, "Hi")
+	glyphs := len(fs.Fonts[font].Glyphs)
+
+	for range 5 {
+		fs.PushState()
+	}
+	fs.SetSize(40)
+	fs.DrawRect(0, 0, 10, 10, 0xffffffff)
+	rec.Vertices = nil
+	fs.Reset()
+
+	if len(fs.States) != 1 || fs.getState().Size != 12 || fs.getState().Font != 0 {
+		t.Errorf("Expected one cleared state, have %d with %+v", len(fs.States), *fs.getState())
+	}
+	if fs.NVerts != 0 || len(rec.Vertices) != 0 {
+		t.Errorf("Expected queued vertices to be dropped, not drawn")
+	}
+	if !fs.Dirty.Empty() {
+		t.Errorf("Expected an empty dirty rect, have %v", fs.Dirty)
+	}
+	if len(fs.Fonts) != 1 || len(fs.Fonts[font].Glyphs) != glyphs {
+		t.Errorf("Expected fonts and cached glyphs to survive Reset")
+	}
+}
+
+func TestStateStackMisuse(t *testing.T) {
+	var errs []error
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, ErrorCallback: func(err error) { errs = append(errs, err) }})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	if _, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf"); err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	fs.SetSize(30)
+	fs.PopState()
+	fs.PopState()
+	if len(errs) != 2 || errs[0] != ErrStatesUnderflow || len(fs.States) != 1 {
+		t.Errorf("Expected over-popping to report underflow and keep one state, got %v with %d states", errs, len(fs.States))
+	}
+	fs.ClearState()
+	if fs.getState().Size != 12 {
+		t.Errorf("Expected ClearState to restore the default size, got %v", fs.getState().Size)
+	}
+
+	// A stack emptied by hand gets a default state back instead of panicking.
+	fs.States = fs.States[:0]
+	fs.ClearState()
+	fs.States = fs.States[:0]
+	fs.SetSize(20)
+	if len(fs.States) != 1 || fs.getState().Size != 20 || fs.getState().Transform != identityTransform {
+		t.Errorf("Expected a single default state, have %d: %+v", len(fs.States), fs.States)
+	}
+	fs.States = fs.States[:0]
+	fs.PushState()
+	if len(fs.States) != 1 || fs.getState().Align != AlignLeft|AlignBaseline {
+		t.Errorf("Expected PushState on an empty stack to push the default, have %+v", fs.States)
+	}
+	if x := fs.DrawText(0, 20, "ok"); x <= 0 {
+		t.Errorf("Expected text to draw after recovering the stack")
+	}
+}
+
+func TestSetStateDefaults(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fs.SetStateDefaults(State{Size: 18, Color: 0xff00ff00, Align: AlignCenter | AlignTop})
+	if s := fs.getState(); s.Size != 18 || s.Color != 0xff00ff00 || s.Transform != identityTransform {
+		t.Errorf("Expected the current state to take the defaults, got %+v", *s)
+	}
+
+	fs.PushState()
+	fs.SetSize(40)
+	fs.SetAlign(AlignRight)
+	fs.ClearState()
+	if s := fs.getState(); s.Size != 18 || s.Align != AlignCenter|AlignTop {
+		t.Errorf("Expected ClearState to use the defaults, got %+v", *s)
+	}
+	fs.PopState()
+	fs.Reset()
+	if s := fs.getState(); s.Size != 18 {
+		t.Errorf("Expected Reset to use the defaults, got %+v", *s)
+	}
+}
+
+func TestSaveRestoreState(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	want := State{
+		Font:       3,
+		Align:      AlignRight | AlignBottom,
+		Size:       21.5,
+		Color:      0x80402010,
+		Blur:       2,
+		Spacing:    1.5,
+		SpacingEm:  0.1,
+		Direction:  DirTTB,
+		Decoration: DecorationUnderline | DecorationStrikethrough,
+		Transform:  [6]float32{2, 0.5, -0.5, 2, 10, 20},
+		Clip:       [4]float32{1, 2, 300, 400},
+	}
+	fs.RestoreState(want)
+	if got := fs.SaveState(); got != want {
+		t.Errorf("Round trip gave %+v, want %+v", got, want)
+	}
+
+	// The saved copy is independent of the stack.
+	saved := fs.SaveState()
+	fs.SetSize(8)
+	fs.PushState()
+	fs.SetSize(9)
+	if saved.Size != 21.5 {
+		t.Errorf("Saved state changed with the stack")
+	}
+	fs.RestoreState(saved)
+	if len(fs.States) != 2 || fs.getState().Size != 21.5 || fs.States[0].Size != 8 {
+		t.Errorf("Expected RestoreState to replace only the top state")
+	}
+}
+
+func TestSupersample(t *testing.T) {
+	var base *image.Alpha
+	var baseGlyph Glyph
+	for _, n := range []int{1, 2, 4} {
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, Supersample: n})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		img, g, err := fs.GlyphBitmap(font, 'W', 9, 0)
+		if err != nil {
+			t.Fatalf("GlyphBitmap: %v", err)
+		}
+		if base == nil {
+			base, baseGlyph = img, g
+			continue
+		}
+
+		if g.XAdv != baseGlyph.XAdv || g.XOff != baseGlyph.XOff || g.YOff != baseGlyph.YOff || img.Bounds() != base.Bounds() {
+			t.Errorf("%dx: glyph metrics changed from %+v to %+v", n, baseGlyph, g)
+			continue
+		}
+		// Partial coverage is kept rather than thresholded, and the total
+		// ink is preserved by the box filter.
+		var partial, basePartial, ink, baseInk int
+		for i, p := range img.Pix {
+			if p > 0 && p < 255 {
+				partial++
+			}
+			if q := base.Pix[i]; q > 0 && q < 255 {
+				basePartial++
+			}
+			ink += int(p)
+			baseInk += int(base.Pix[i])
+		}
+		if partial < basePartial*9/10 {
+			t.Errorf("%dx: %d partially covered pixels, 1x has %d", n, partial, basePartial)
+		}
+		if d := ink - baseInk; d*100 > baseInk || -d*100 > baseInk {
+			t.Errorf("%dx: total coverage %d differs from 1x %d", n, ink, baseInk)
+		}
+	}
+}
+
+func TestSupersampleHistogram(t *testing.T) {
+	// coverage buckets a glyph's pixels into eight coverage levels.
+	coverage := func(n int, r rune) (h [8]int, total int) {
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, Supersample: n})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		img, _, err := fs.GlyphBitmap(font, r, 24, 0)
+		if err != nil {
+			t.Fatalf("GlyphBitmap: %v", err)
+		}
+		for _, p := range img.Pix {
+			h[p/32]++
+		}
+		return h, len(img.Pix)
+	}
+	for _, r := range "W@gs" {
+		base, total := coverage(1, r)
+		h, _ := coverage(2, r)
+		// The edges stay as smooth as 1x: the coverage levels are spread
+		// alike rather than pushed to empty or full.
+		diff, partial, basePartial := 0, 0, 0
+		for i := range h {
+			diff += max(h[i]-base[i], base[i]-h[i])
+			if i > 0 && i < len(h)-1 {
+				partial += h[i]
+				basePartial += base[i]
+			}
+		}
+		if diff*20 > total {
+			t.Errorf("%q: 2x coverage histogram %v strays from 1x %v", r, h, base)
+		}
+		if partial*10 < basePartial*8 {
+			t.Errorf("%q: 2x has %d partially covered pixels, 1x has %d", r, partial, basePartial)
+		}
+	}
+}
+
+func TestKerning(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	regular, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.AddFallbackFont(font, regular)
+	fs.SetFont(font)
+	fs.SetSize(40)
+
+	kern := fs.Kerning(font, 'A', 'V', 40)
+	if kern >= 0 {
+		t.Errorf("Expected AV to kern tighter, got %v", kern)
+	}
+	pair := fs.TextBounds(0, 0, "AV", nil)
+	if want := fs.TextBounds(0, 0, "A", nil) + fs.TextBounds(0, 0, "V", nil) + kern; pair != want {
+		t.Errorf("Expected AV to advance %v as DrawText does, got %v", want, pair)
+	}
+	if k := fs.Kerning(font, 'H', 'H', 40); k != 0 {
+		t.Errorf("Expected no kerning for HH, got %v", k)
+	}
+	if k := fs.Kerning(font, 'A', '→', 40); k != 0 {
+		t.Errorf("Expected no kerning across fallback fonts, got %v", k)
+	}
+	if k := fs.Kerning(font, 'A', 'あ', 40); k != 0 {
+		t.Errorf("Expected no kerning with a missing glyph, got %v", k)
+	}
+	if k := fs.Kerning(42, 'A', 'V', 40); k != 0 {
+		t.Errorf("Expected no kerning for an invalid handle, got %v", k)
+	}
+}
+
+func TestRepackAtlas(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 128, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	keep, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	drop, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	// Interleave glyphs of both fonts, then remove one, leaving holes.
+	for _, r := range "abcdefghij" {
+		for _, font := range []int{keep, drop} {
+			if _, _, err := fs.GlyphBitmap(font, r, 24, 0); err != nil {
+				t.Fatalf("GlyphBitmap: %v", err)
+			}
+		}
+	}
+	before, _, _ := fs.GlyphBitmap(keep, 'g', 24, 0)
+	if err := fs.RemoveFont(drop); err != nil {
+		t.Fatalf("RemoveFont: %v", err)
+	}
+	if _, _, ok := fs.ReserveRect(240, 90); ok {
+		t.Fatalf("Expected the fragmented atlas to have no room")
+	}
+
+	if !fs.RepackAtlas() {
+		t.Fatalf("RepackAtlas failed")
+	}
+	if _, _, ok := fs.ReserveRect(240, 90); !ok {
+		t.Errorf("Expected repacking to reclaim the removed font's space")
+	}
+	after, _, _ := fs.GlyphBitmap(keep, 'g', 24, 0)
+	if !bytes.Equal(before.Pix, after.Pix) || len(fs.Fonts[keep].Glyphs) != 10 {
+		t.Errorf("Expected cached glyphs to survive repacking")
+	}
+	u, v := fs.WhiteRectUV()
+	if x, y := int(u*float32(fs.Width)), int(v*float32(fs.Height)); fs.TexData[y*fs.Width+x] != 0xff {
+		t.Errorf("Expected the white rect to move with the repack")
+	}
+	if fs.Dirty.Empty() {
+		t.Errorf("Expected the repacked atlas to be marked for upload")
+	}
+}
+
+func TestGlyphResolvedCallback(t *testing.T) {
+	type resolved struct {
+		r        rune
+		font     int
+		fallback bool
+	}
+	var got []resolved
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, GlyphResolvedCallback: func(r rune, font int, fallback bool) {
+		got = append(got, resolved{r, font, fallback})
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	base, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	regular, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.AddFallbackFont(base, regular)
+	fs.SetFont(base)
+
+	fs.DrawText(0, 20, "a→あa")
+	fs.DrawText(0, 20, "a→")
+	want := []resolved{{'a', base, false}, {'→', regular, true}, {'あ', -1, false}}
+	if !slices.Equal(got, want) {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+}
+
+type tightRenderer struct {
+	MockRenderer
+	Rects []image.Rectangle
+	Data  [][]byte
+}
+
+func (r *tightRenderer) UpdateTight(rect image.Rectangle, sub []byte, stride int) {
+	if stride != rect.Dx() || len(sub) != rect.Dx()*rect.Dy() {
+		panic("UpdateTight buffer is not tightly packed")
+	}
+	r.Rects = append(r.Rects, rect)
+	r.Data = append(r.Data, append([]byte(nil), sub...))
+}
+
+func TestUpdateTight(t *testing.T) {
+	rec := &tightRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: rec})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	fs.DrawText(0, 20, "abc")
+	if len(rec.Rects) != 1 || rec.Updates != 0 {
+		t.Fatalf("Expected one UpdateTight and no Update, got %d and %d", len(rec.Rects), rec.Updates)
+	}
+	r, sub := rec.Rects[0], rec.Data[0]
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := fs.TexData[y*fs.Width+r.Min.X : y*fs.Width+r.Max.X]
+		if !bytes.Equal(sub[(y-r.Min.Y)*r.Dx():(y-r.Min.Y+1)*r.Dx()], row) {
+			t.Fatalf("Row %d of the packed region doesn't match the atlas", y)
+		}
+	}
+}
+
+func TestDrawStats(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: mock, MaxVertices: 60})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(font)
+
+	fs.DrawRect(0, 0, 4, 4, 0xffffffff)
+	fs.DrawText(0, 20, "Hello, fontstash")
+	draws, verts := fs.DrawStats()
+	if draws != uint64(mock.Draws) || verts != uint64(mock.Verts) || draws < 2 {
+		t.Errorf("DrawStats %d, %d disagree with renderer %d, %d", draws, verts, mock.Draws, mock.Verts)
+	}
+
+	fs.ResetDrawStats()
+	if draws, verts := fs.DrawStats(); draws != 0 || verts != 0 {
+		t.Errorf("Expected zeroed stats, got %d, %d", draws, verts)
+	}
+	fs.DrawText(0, 20, "Hi")
+	if draws, verts := fs.DrawStats(); draws != 1 || verts != 2*vertsPerQuad {
+		t.Errorf("Expected one draw of two quads, got %d, %d", draws, verts)
+	}
+}
+
+func TestAtlasNodeCapacity(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, AtlasNodeCapacity: 4096})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	if c := cap(fs.Atlas.nodes); c != 4096 {
+		t.Errorf("Expected a skyline presized to 4096 nodes, got %d", c)
+	}
+	font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	before := fs.AtlasNodeCount()
+	fs.SetFont(font)
+	fs.DrawText(0, 20, "skyline")
+	if n := fs.AtlasNodeCount(); n <= before || n != len(fs.Atlas.nodes) {
+		t.Errorf("Expected the node count to grow from %d, got %d", before, n)
+	}
+}
+
+func TestTextLineBounds(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+	fs.SetAlign(AlignLeft | AlignBaseline)
+
+	var inkShort, inkTall, lineShort, lineTall [4]float32
+	fs.TextBounds(10, 50, "ace", &inkShort)
+	fs.TextBounds(10, 50, "Agpy", &inkTall)
+	advShort := fs.TextLineBounds(10, 50, "ace", &lineShort)
+	fs.TextLineBounds(10, 50, "Agpy", &lineTall)
+
+	if inkShort[3]-inkShort[1] >= inkTall[3]-inkTall[1] {
+		t.Errorf("Expected \"ace\" to have less ink height than \"Agpy\", got %v and %v", inkShort, inkTall)
+	}
+	if lineShort[1] != lineTall[1] || lineShort[3] != lineTall[3] {
+		t.Errorf("Expected equal line boxes, got %v and %v", lineShort, lineTall)
+	}
+	asc, desc, _ := fs.VertMetrics()
+	if math.Abs(float64(lineShort[1]-(50-asc))) > 0.01 || math.Abs(float64(lineShort[3]-(50-desc))) > 0.01 {
+		t.Errorf("Expected the line box to span %v to %v, got %v", 50-asc, 50-desc, lineShort)
+	}
+	if advShort != fs.TextBounds(10, 50, "ace", nil) || lineShort[0] != inkShort[0] || lineShort[2] != inkShort[2] {
+		t.Errorf("Expected horizontal bounds to match TextBounds, got %v and %v", lineShort, inkShort)
+	}
+}
+
+func TestBlurBounds(t *testing.T) {
+	measure := func(blurBounds bool, blur float32) (ink, line [4]float32) {
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}, BlurBounds: blurBounds})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(20)
+		fs.SetBlur(blur)
+		fs.TextBounds(10, 50, "Hi", &ink)
+		fs.TextLineBounds(10, 50, "Hi", &line)
+		return ink, line
+	}
+
+	ink, line := measure(false, 0)
+	if gotInk, gotLine := measure(true, 0); gotInk != ink || gotLine != line {
+		t.Errorf("Expected unblurred bounds to be unchanged, got %v and %v, want %v and %v", gotInk, gotLine, ink, line)
+	}
+
+	ink, line = measure(false, 4)
+	gotInk, gotLine := measure(true, 4)
+	want := [4]float32{-4, -4, 4, 4}
+	for i := range want {
+		if gotInk[i]-ink[i] != want[i] || gotLine[i]-line[i] != want[i] {
+			t.Errorf("Expected bounds grown by the blur, got %v and %v from %v and %v", gotInk, gotLine, ink, line)
+			break
+		}
+	}
+}
+
+func TestForEachQuad(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: rec})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+	fs.SetColor(0xff336699)
+	fs.SetDecoration(DecorationUnderline)
+
+	const str = "Hello, quads"
+	var quads []Quad
+	x := fs.ForEachQuad(10, 40, str, func(q Quad, color uint32) {
+		if color != 0xff336699 {
+			t.Errorf("Expected the state color, got %#x", color)
+		}
+		quads = append(quads, q)
+	})
+	if rec.Draws != 0 || fs.NVerts != 0 {
+		t.Errorf("Expected no vertices, got %d draws and %d queued", rec.Draws, fs.NVerts)
+	}
+	if rec.Updates == 0 || !fs.Dirty.Empty() {
+		t.Errorf("Expected the atlas to be uploaded, got %d updates and dirty %v", rec.Updates, fs.Dirty)
+	}
+
+	if drawn := fs.DrawText(10, 40, str); drawn != x {
+		t.Errorf("Expected DrawText to end at %v, got %v", x, drawn)
+	}
+	if len(rec.Vertices) != len(quads)*vertsPerQuad {
+		t.Fatalf("Expected %d quads to match %d verts", len(quads), len(rec.Vertices))
+	}
+	for i, q := range quads {
+		v := rec.Vertices[i*vertsPerQuad]
+		w := rec.Vertices[i*vertsPerQuad+1]
+		if (Quad{v.X, v.Y, v.U, v.V, w.X, w.Y, w.U, w.V}) != q {
+			t.Errorf("Quad %d = %+v, drawn as %+v and %+v", i, q, v, w)
+		}
+	}
+}
+
+func TestFaceCache(t *testing.T) {
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	serif, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	mono, err := fs.AddFontFromBytes("mono", gomono.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	fs.SetFont(serif)
+	fs.SetSize(20)
+	fs.DrawText(0, 30, "abc")
+	fs.SetSize(30)
+	fs.DrawText(0, 60, "abc")
+	fs.SetFont(mono)
+	fs.DrawText(0, 90, "abc")
+	if len(fs.faces) != 3 {
+		t.Fatalf("Expected a face per font and size, got %d", len(fs.faces))
+	}
+
+	if err := fs.SetHinting(serif, font.HintingNone); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.faces[faceKey{serif, 200}]; ok || len(fs.faces) != 1 {
+		t.Errorf("Expected SetHinting to close the font's faces, got %v", fs.faces)
+	}
+	fs.SetFont(serif)
+	fs.DrawText(0, 30, "abc")
+	if len(fs.faces) != 2 {
+		t.Errorf("Expected the face to be recreated, got %d", len(fs.faces))
+	}
+
+	if err := fs.RemoveFont(mono); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.faces[faceKey{mono, 300}]; ok {
+		t.Errorf("Expected RemoveFont to close the font's faces")
+	}
+	fs.CloseFaces()
+	if len(fs.faces) != 0 {
+		t.Errorf("Expected CloseFaces to close every face, got %d", len(fs.faces))
+	}
+	fs.DrawText(0, 30, "xyz")
+	fs.ResetAtlas(512, 512)
+	if len(fs.faces) != 0 {
+		t.Errorf("Expected ResetAtlas to close every face, got %d", len(fs.faces))
+	}
+
+	// Once the face is open, a miss at its size rasterizes into buffers
+	// the face already holds.
+	f := fs.Fonts[serif]
+	fs.getGlyph(f, 'g', 200, 0, 0)
+	allocs := testing.AllocsPerRun(100, func() {
+		f.Glyphs = f.Glyphs[:0]
+		for i := range f.Lut {
+			f.Lut[i] = -1
+		}
+		fs.Atlas.reset(512, 512)
+		fs.getGlyph(f, 'g', 200, 0, 0)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected a glyph miss with a cached face not to allocate, got %f allocs", allocs)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for _, normalize := range []bool{false, true} {
+		mock := &MockRenderer{}
+		fs, err := New(Params{Width: 256, Height: 256, Renderer: mock, Normalize: normalize, ShowMissingGlyph: true})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFontFromBytes("sans", goregular.TTF)
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(20)
+
+		const decomposed, precomposed = "cafe\u0301", "caf\u00e9"
+		quads := 5
+		if normalize {
+			quads = 4
+		}
+		fs.DrawText(10, 30, decomposed)
+		fs.DrawTextBytes(10, 30, []byte(decomposed))
+		if mock.Verts != 2*quads*vertsPerQuad {
+			t.Errorf("Normalize %v: expected %d quads per draw, got %d verts", normalize, quads, mock.Verts)
+		}
+
+		// The font has no combining acute, so unnormalized it draws as a
+		// missing glyph box.
+		var got, want [4]float32
+		adv := fs.TextBounds(10, 30, decomposed, &got)
+		wantAdv := fs.TextBounds(10, 30, precomposed, &want)
+		if (got == want && adv == wantAdv) != normalize {
+			t.Errorf("Normalize %v: bounds %v advance %v, precomposed %v advance %v", normalize, got, adv, want, wantAdv)
+		}
+
+		// Wrapping measures the text as it is drawn.
+		_, width, _, _ := fs.TextBoxBounds(10, 30, 0, decomposed)
+		_, wantWidth, _, _ := fs.TextBoxBounds(10, 30, 0, precomposed)
+		if (width == wantWidth) != normalize {
+			t.Errorf("Normalize %v: box width %v, precomposed %v", normalize, width, wantWidth)
+		}
+		mock.Verts = 0
+		fs.DrawTextBox(10, 30, 0, decomposed)
+		if mock.Verts != quads*vertsPerQuad {
+			t.Errorf("Normalize %v: expected %d quads in a box, got %d verts", normalize, quads, mock.Verts)
+		}
+		mock.Verts = 0
+		fs.DrawRuns(10, 30, []TextRun{{Text: decomposed, Font: fontNormal, Size: 20}})
+		if mock.Verts != quads*vertsPerQuad {
+			t.Errorf("Normalize %v: expected %d quads in a run, got %d verts", normalize, quads, mock.Verts)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	base, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fallback, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.AddFallbackFont(base, fallback)
+	fs.SetFont(base)
+	fs.SetSize(20)
+	fs.DrawText(10, 30, "Hello")
+
+	c := fs.Clone()
+	if len(c.Fonts) != 2 || &c.Fonts[base].Data[0] != &fs.Fonts[base].Data[0] || c.Fonts[base].sfnt != fs.Fonts[base].sfnt {
+		t.Fatalf("Expected the clone to share the font data")
+	}
+	if len(c.Fonts[base].Glyphs) != 0 || c.getState().Font != base || c.getState().Size != 20 {
+		t.Errorf("Expected an empty glyph cache and the same state, got %d glyphs and %+v", len(c.Fonts[base].Glyphs), c.getState())
+	}
+	if &c.TexData[0] == &fs.TexData[0] || c.Atlas == fs.Atlas {
+		t.Errorf("Expected the clone to have its own atlas")
+	}
+	c.AddFallbackFont(base, base)
+	if len(fs.Fonts[base].Fallbacks) != 1 {
+		t.Errorf("Expected fallbacks added to the clone to leave the original alone, got %v", fs.Fonts[base].Fallbacks)
+	}
+
+	// Clones lay out the same text identically, each on its own goroutine.
+	want := fs.TextBounds(0, 0, "The quick brown fox", nil)
+	got := make([]float32, 4)
+	var wg sync.WaitGroup
+	for i := range got {
+		c := fs.Clone()
+		c.Params.Renderer = &MockRenderer{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.DrawText(10, 30, "The quick brown fox")
+			got[i] = c.TextBounds(0, 0, "The quick brown fox", nil)
+		}()
+	}
+	wg.Wait()
+	for i, adv := range got {
+		if adv != want {
+			t.Errorf("Clone %d: expected advance %v, got %v", i, want, adv)
+		}
+	}
+}
+
+func TestMaxFontSize(t *testing.T) {
+	var errs []error
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: rec, ErrorCallback: func(err error) { errs = append(errs, err) }})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(5000)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrFontSize) {
+		t.Fatalf("Expected ErrFontSize, got %v", errs)
+	}
+	if size := fs.getState().Size; size != MaxFontSize {
+		t.Errorf("Expected the size clamped to %v, got %v", float32(MaxFontSize), size)
+	}
+
+	// Too big for the atlas, so it reports that and draws nothing.
+	errs = nil
+	fs.DrawText(0, 0, "A")
+	if len(rec.Vertices) != 0 || len(errs) == 0 || !errors.Is(errs[0], ErrAtlasFull) {
+		t.Errorf("Expected ErrAtlasFull and no vertices, got %v and %d vertices", errs, len(rec.Vertices))
+	}
+
+	// Without an atlas to fill, it measures as the largest size rather
+	// than a wrapped one.
+	m, err := NewMeasurer(Params{})
+	if err != nil {
+		t.Fatalf("Failed to create measurer: %v", err)
+	}
+	fontNormal, err = m.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	m.SetFont(fontNormal)
+	m.SetSize(5000)
+	var bounds [4]float32
+	adv := m.TextBounds(0, 0, "A", &bounds)
+	m.SetSize(MaxFontSize)
+	if want := m.TextBounds(0, 0, "A", nil); adv != want || adv < 1000 {
+		t.Errorf("Expected advance %v, got %v", want, adv)
+	}
+	if bounds[2]-bounds[0] < 1000 || bounds[3]-bounds[1] < 1000 {
+		t.Errorf("Expected large bounds, got %v", bounds)
+	}
+}
+
+func TestTrailingSpaceAdvance(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	// The trailing space moves the pen but adds no ink.
+	short, spaced := fs.TextMetrics("i"), fs.TextMetrics("i ")
+	if spaced.Advance <= short.Advance || spaced.InkMinX != short.InkMinX || spaced.InkMaxX != short.InkMaxX {
+		t.Errorf("Expected a longer advance and the same ink, got %+v and %+v", short, spaced)
+	}
+	if adv := fs.TextBounds(0, 0, "i ", nil); adv != spaced.Advance {
+		t.Errorf("Expected TextBounds to return the advance %v, got %v", spaced.Advance, adv)
+	}
+}
+
+func TestScaleXY(t *testing.T) {
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(font)
+	fs.SetSize(20)
+	const text = "Hello Wave"
+	var plain, bounds [4]float32
+	advance := fs.TextBounds(0, 0, text, &plain)
+
+	// A uniform scale is the same as the scaled size.
+	fs.SetScaleXY(2, 2)
+	scaledAdvance := fs.TextBounds(0, 0, text, &bounds)
+	fs.SetScaleXY(1, 1)
+	fs.SetSize(40)
+	var big [4]float32
+	if adv := fs.TextBounds(0, 0, text, &big); adv != scaledAdvance || big != bounds {
+		t.Errorf("Expected a scale of 2 to match size 40: %v %v, got %v %v", adv, big, scaledAdvance, bounds)
+	}
+	fs.SetSize(20)
+
+	// Stretching one axis leaves the other alone.
+	fs.SetScaleXY(2, 1)
+	adv := fs.TextBounds(0, 0, text, &bounds)
+	if math.Abs(float64(adv-2*advance)) > 0.01 {
+		t.Errorf("Expected a horizontal scale of 2 to double the advance %v, got %v", advance, adv)
+	}
+	if w, want := bounds[2]-bounds[0], 2*(plain[2]-plain[0]); math.Abs(float64(w-want)) > 2 {
+		t.Errorf("Expected bounds about %v wide, got %v", want, bounds)
+	}
+	if bounds[1] != plain[1] || bounds[3] != plain[3] {
+		t.Errorf("Expected the vertical bounds %v unchanged, got %v", plain, bounds)
+	}
+
+	// Glyphs are rasterized at the vertically scaled size, whose rounded
+	// advances can differ slightly once halved.
+	fs.SetScaleXY(1, 2)
+	adv = fs.TextBounds(0, 0, text, &bounds)
+	if math.Abs(float64(adv-advance)) > 1 {
+		t.Errorf("Expected a vertical scale to keep the advance %v, got %v", advance, adv)
+	}
+	if bounds[1] != big[1] || bounds[3] != big[3] {
+		t.Errorf("Expected the vertical bounds %v of size 40, got %v", big, bounds)
+	}
+	if asc, _, _ := fs.VertMetrics(); asc != fs.Fonts[font].Ascender*40 {
+		t.Errorf("Expected the ascender at size 40, got %v", asc)
+	}
+
+	// Scales that aren't positive are ignored.
+	fs.SetScaleXY(1, 0)
+	fs.SetScaleXY(-1, 1)
+	if s := fs.SaveState(); s.ScaleX != 1 || s.ScaleY != 2 {
+		t.Errorf("Expected the scale 1, 2 to be kept, got %v, %v", s.ScaleX, s.ScaleY)
+	}
+
+	// The scaled size is clamped like the size.
+	fs.SetSize(MaxFontSize)
+	if asc, _, _ := fs.VertMetrics(); asc != fs.Fonts[font].Ascender*MaxFontSize {
+		t.Errorf("Expected the ascender at MaxFontSize, got %v", asc)
+	}
+	fs.SetSize(20)
+
+	// A zero scale, as in a State literal, is unscaled.
+	s := fs.SaveState()
+	s.ScaleX, s.ScaleY = 0, 0
+	fs.RestoreState(s)
+	if adv := fs.TextBounds(0, 0, text, &bounds); adv != advance || bounds != plain {
+		t.Errorf("Expected a zero scale to be unscaled: %v %v, got %v %v", advance, plain, adv, bounds)
+	}
+}
+
+func TestDrawTextBounds(t *testing.T) {
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}, BlurBounds: true})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	const text = "Jolly quay"
+	for _, tc := range []struct {
+		name      string
+		align     int
+		direction int
+		blur      float32
+		transform [6]float32
+	}{
+		{"left", AlignLeft | AlignBaseline, DirLTR, 0, identityTransform},
+		{"center", AlignCenter | AlignMiddle, DirLTR, 0, identityTransform},
+		{"right", AlignRight | AlignTop, DirLTR, 0, identityTransform},
+		{"blurred", AlignLeft | AlignBottom, DirLTR, 3, identityTransform},
+		{"transformed", AlignLeft | AlignBaseline, DirLTR, 0, [6]float32{2, 0.5, -0.5, 2, 10, 20}},
+		{"vertical", AlignLeft | AlignBaseline, DirTTB, 0, identityTransform},
+	} {
+		fs.ClearState()
+		fs.SetFont(fontNormal)
+		fs.SetSize(24)
+		fs.SetAlign(tc.align)
+		fs.SetDirection(tc.direction)
+		fs.SetBlur(tc.blur)
+		fs.SetTransform(tc.transform)
+
+		var want, got [4]float32
+		fs.TextBounds(10.3, 50, text, &want)
+		fs.ResetCacheCounters()
+		end := fs.DrawText(10.3, 50, text)
+		drawHits, _ := fs.CacheCounters()
+		fs.ResetCacheCounters()
+		if boundsEnd := fs.DrawTextBounds(10.3, 50, text, &got); boundsEnd != end {
+			t.Errorf("%s: expected the pen to end at %v, got %v", tc.name, end, boundsEnd)
+		}
+		if got != want {
+			t.Errorf("%s: expected the TextBounds %v, got %v", tc.name, want, got)
+		}
+		// Nothing is laid out beyond what drawing needs.
+		if hits, _ := fs.CacheCounters(); hits != drawHits {
+			t.Errorf("%s: expected %d glyph lookups as in DrawText, got %d", tc.name, drawHits, hits)
+		}
+	}
+}
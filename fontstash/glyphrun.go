@@ -0,0 +1,181 @@
+package fontstash
+
+import (
+	"image"
+	"image/draw"
+
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// PositionedGlyph is one glyph of a run shaped outside the package, e.g.
+// by HarfBuzz. GlyphIndex is the glyph's index in the current font.
+// Offsets and advance are in pixels; a positive YOffset moves the glyph up
+// the screen, as shapers report it.
+type PositionedGlyph struct {
+	GlyphIndex       int
+	XOffset, YOffset float32
+	XAdvance         float32
+}
+
+// DrawGlyphRun draws pre-shaped glyphs from the current font, which must
+// be the font the run was shaped with: indices are not resolved through
+// fallbacks, and kerning and spacing are left to the shaper. Alignment,
+// color, blur, clipping, the transform and SetScaleXY, which scales the
+// run's offsets and advances too, apply as in DrawText, and glyphs come
+// from the font's bitmap strikes or outlines at the subpixel phase of
+// their position as they do there. Params.Rasterizer, which works by
+// codepoint, is not used. It returns the pen x after the run.
+func (fs *FontStash) DrawGlyphRun(x, y float32, run []PositionedGlyph) float32 {
+	state := fs.getState()
+	f := fs.getFont(state.Font)
+	if f == nil || f.Data == nil {
+		return x
+	}
+	isize := fs.sizeKey(state.layoutSize())
+	iblur := int16(state.Blur)
+	sx, sy := state.scaleX(), state.scaleY()
+
+	var width float32
+	for _, pg := range run {
+		width += pg.XAdvance * sx
+	}
+	if state.Align&AlignLeft != 0 {
+		// empty
+	} else if state.Align&AlignRight != 0 {
+		x -= width
+	} else if state.Align&AlignCenter != 0 {
+		x -= width * 0.5
+	}
+	y = fs.snapBaseline(state, y+fs.getVertAlign(f, state.Align, isize))
+
+	startX := x
+	color := fs.vertexColor(state.Color)
+	xf := state.vertexTransform()
+	q := Quad{}
+	for _, pg := range run {
+		glyph, err := fs.getGlyphByIndex(f, pg.GlyphIndex, isize, iblur, 0)
+		if err == nil && glyph != nil && !glyph.empty() {
+			page := fs.placeGlyph(f, glyph, state.stretch(), x+pg.XOffset*sx, y-fs.ySign*pg.YOffset*sy, &q)
+			fs.emitQuad(state, xf, &q, page, color)
+		}
+		x += pg.XAdvance * sx
+	}
+
+	if state.Decoration != 0 {
+		fs.drawDecorations(f, state, startX, x, y)
+	}
+	fs.flush()
+	return x
+}
+
+// getGlyphByIndex is getGlyph for a glyph index, cached under ^index.
+func (fs *FontStash) getGlyphByIndex(f *Font, index int, isize, iblur, phase int16) (*Glyph, error) {
+	if isize < minFontSize {
+		return nil, nil
+	}
+	iblur = max(0, min(iblur, maxBlur))
+
+	key := ^rune(index)
+	h, g := f.findGlyph(key, isize, iblur, phase)
+	if g != nil {
+		fs.cacheHits++
+		return g, nil
+	}
+	fs.cacheMisses++
+
+	size := float64(isize) / sizeScale
+	dot := fixed.Point26_6{X: fixed.Int26_6(int(phase) * 64 / subpixelPhases)}
+	var (
+		dr      image.Rectangle
+		cover   image.Image
+		advance fixed.Int26_6
+		err     error
+	)
+	if sdr, smask, sadv, ok := fs.strikeGlyph(f, index, size); ok {
+		dr, advance = sdr, fixed.I(sadv)
+		if smask != nil {
+			cover = smask
+		}
+	} else if fs.Params.MeasureOnly {
+		dr, advance, err = fs.outlineBounds(f, index, size, dot)
+	} else {
+		n := fs.Params.Supersample
+		var mask *image.Alpha
+		dr, mask, advance, err = fs.rasterizeIndex(f, sfnt.GlyphIndex(index), size*float64(fs.dpiScale)*float64(n), dot.Mul(fixed.I(n)))
+		if mask != nil {
+			cover = mask
+		}
+		if err == nil && n > 1 {
+			if mask != nil {
+				dr, cover = downsample(dr, mask, image.Point{}, n)
+			}
+			advance, err = fs.glyphAdvance(f, index, size)
+		}
+	}
+	if err != nil {
+		return nil, fs.rasterError(key, err)
+	}
+	return fs.packGlyph(f, h, Glyph{
+		Codepoint: key,
+		Size:      isize,
+		Blur:      iblur,
+		Phase:     phase,
+		Index:     index,
+		XAdv:      (int32(advance)*sizeScale + 32) / 64,
+		Font:      f.handle,
+	}, dr, cover, image.Point{})
+}
+
+// rasterizeIndex renders glyph index x of f at size with its origin at dot.
+func (fs *FontStash) rasterizeIndex(f *Font, x sfnt.GlyphIndex, size float64, dot fixed.Point26_6) (image.Rectangle, *image.Alpha, fixed.Int26_6, error) {
+	segments, dr, advance, err := fs.loadOutline(f, x, size, dot)
+	if err != nil || dr.Empty() {
+		return image.Rectangle{}, nil, advance, err
+	}
+	biasX := dot.X - fixed.I(dr.Min.X)
+	biasY := dot.Y - fixed.I(dr.Min.Y)
+	pt := func(p fixed.Point26_6) (float32, float32) {
+		return float32(p.X+biasX) / 64, float32(p.Y+biasY) / 64
+	}
+
+	r := vector.NewRasterizer(dr.Dx(), dr.Dy())
+	r.DrawOp = draw.Src
+	for _, seg := range segments {
+		x0, y0 := pt(seg.Args[0])
+		switch seg.Op {
+		case sfnt.SegmentOpMoveTo:
+			r.MoveTo(x0, y0)
+		case sfnt.SegmentOpLineTo:
+			r.LineTo(x0, y0)
+		case sfnt.SegmentOpQuadTo:
+			x1, y1 := pt(seg.Args[1])
+			r.QuadTo(x0, y0, x1, y1)
+		case sfnt.SegmentOpCubeTo:
+			x1, y1 := pt(seg.Args[1])
+			x2, y2 := pt(seg.Args[2])
+			r.CubeTo(x0, y0, x1, y1, x2, y2)
+		}
+	}
+	mask := image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+	r.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+	return dr, mask, advance, nil
+}
+
+// loadOutline loads the outline, pixel bounds and advance of glyph x of f.
+func (fs *FontStash) loadOutline(f *Font, x sfnt.GlyphIndex, size float64, dot fixed.Point26_6) (sfnt.Segments, image.Rectangle, fixed.Int26_6, error) {
+	// Scale the same way opentype.NewFace does.
+	ppem := fixed.Int26_6(0.5 + size*64)
+	advance, err := f.sfnt.GlyphAdvance(&fs.sfntBuf, x, ppem, f.hinting)
+	if err != nil {
+		return nil, image.Rectangle{}, 0, err
+	}
+	segments, err := f.sfnt.LoadGlyph(&fs.sfntBuf, x, ppem, nil)
+	if err != nil {
+		return nil, image.Rectangle{}, 0, err
+	}
+	b := segments.Bounds().Add(dot)
+	dr := image.Rect(b.Min.X.Floor(), b.Min.Y.Floor(), b.Max.X.Ceil(), b.Max.Y.Ceil())
+	return segments, dr, advance, nil
+}
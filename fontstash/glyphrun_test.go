@@ -0,0 +1,135 @@
+package fontstash
+
+import (
+	"math"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestDrawGlyphRun(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: rec})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(24)
+
+	runes := []rune("Hi")
+	advances := fs.GlyphAdvances(runes, nil)
+	end := fs.DrawText(10, 50, "Hi")
+	want := append([]Vertex(nil), rec.Vertices...)
+	rec.Vertices = nil
+
+	run := make([]PositionedGlyph, len(runes))
+	for i, r := range runes {
+		run[i] = PositionedGlyph{GlyphIndex: fs.getGlyphIndex(fs.Fonts[fontNormal], r), XAdvance: advances[i]}
+	}
+	if got := fs.DrawGlyphRun(10, 50, run); got != end {
+		t.Errorf("DrawGlyphRun returned %v, want %v", got, end)
+	}
+	if len(rec.Vertices) != len(want) {
+		t.Fatalf("Expected %d vertices, got %d", len(want), len(rec.Vertices))
+	}
+	for i := range want {
+		if math.Abs(float64(rec.Vertices[i].X-want[i].X)) > 1e-3 || math.Abs(float64(rec.Vertices[i].Y-want[i].Y)) > 1e-3 {
+			t.Errorf("Vertex %d at %v,%v, want %v,%v", i, rec.Vertices[i].X, rec.Vertices[i].Y, want[i].X, want[i].Y)
+		}
+	}
+
+	// Index-keyed glyphs are cached apart from codepoint-keyed ones.
+	if n := len(fs.Fonts[fontNormal].Glyphs); n != 4 {
+		t.Errorf("Expected 4 cached glyphs, got %d", n)
+	}
+
+	// A positive YOffset raises the glyph.
+	rec.Vertices = nil
+	run[0].YOffset = 5
+	fs.DrawGlyphRun(10, 50, run[:1])
+	if dy := want[0].Y - rec.Vertices[0].Y; math.Abs(float64(dy-5)) > 1e-3 {
+		t.Errorf("Expected glyph raised by 5, moved %v", dy)
+	}
+}
+
+func TestGlyphByIndexMeasureOnly(t *testing.T) {
+	ref, err := New(Params{Width: 512, Height: 512, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	m, err := NewMeasurer(Params{Width: 512, Height: 512})
+	if err != nil {
+		t.Fatalf("Failed to create measurer: %v", err)
+	}
+	var glyphs [2]*Glyph
+	for i, fs := range []*FontStash{ref, m} {
+		font, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		f := fs.Fonts[font]
+		if glyphs[i], err = fs.getGlyphByIndex(f, fs.getGlyphIndex(f, 'g'), 240, 0, 0); err != nil || glyphs[i] == nil {
+			t.Fatalf("getGlyphByIndex = %v, %v", glyphs[i], err)
+		}
+	}
+
+	// The outline gives the same extent without an atlas cell.
+	want, got := glyphs[0], glyphs[1]
+	if got.X0 != 0 || got.Y0 != 0 {
+		t.Errorf("Expected no atlas cell, got %+v", got)
+	}
+	if got.X1 != want.X1-want.X0 || got.Y1 != want.Y1-want.Y0 || got.XOff != want.XOff || got.YOff != want.YOff || got.XAdv != want.XAdv {
+		t.Errorf("Measured %+v, want the extent of %+v", got, want)
+	}
+}
+
+func TestGlyphRunLikeText(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: rec, Subpixel: true})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	plain, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	a, b := fs.getGlyphIndex(fs.Fonts[plain], 'A'), fs.getGlyphIndex(fs.Fonts[plain], 'B')
+	strike, err := fs.AddFontFromBytes("strike", withStrike(a, b))
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	// Both the subpixel phase and the strike bitmaps follow DrawText.
+	for _, tc := range []struct {
+		font int
+		size float32
+	}{{plain, 20}, {strike, 16}} {
+		fs.SetFont(tc.font)
+		fs.SetSize(tc.size)
+		runes := []rune("AB")
+		advances := fs.GlyphAdvances(runes, nil)
+		rec.Vertices = nil
+		fs.DrawText(10.4, 50, "AB")
+		want := append([]Vertex(nil), rec.Vertices...)
+
+		rec.Vertices = nil
+		run := make([]PositionedGlyph, len(runes))
+		for i, r := range runes {
+			run[i] = PositionedGlyph{GlyphIndex: fs.getGlyphIndex(fs.Fonts[tc.font], r), XAdvance: advances[i]}
+		}
+		fs.DrawGlyphRun(10.4, 50, run)
+		if len(rec.Vertices) != len(want) {
+			t.Fatalf("Size %v: expected %d vertices, got %d", tc.size, len(want), len(rec.Vertices))
+		}
+		for i := range want {
+			got := rec.Vertices[i]
+			if math.Abs(float64(got.X-want[i].X)) > 1e-3 || math.Abs(float64(got.Y-want[i].Y)) > 1e-3 {
+				t.Errorf("Size %v: vertex %d at %v,%v, want %v,%v", tc.size, i, got.X, got.Y, want[i].X, want[i].Y)
+			}
+		}
+	}
+}
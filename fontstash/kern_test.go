@@ -0,0 +1,149 @@
+package fontstash
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+)
+
+// withGPOSKern returns a copy of the font data with a GPOS table holding a
+// single 'kern' feature pair adjustment of xAdvance font units for first
+// followed by second.
+func withGPOSKern(data []byte, first, second sfnt.GlyphIndex, xAdvance int16) []byte {
+	be := binary.BigEndian
+	var gpos []byte
+	u16 := func(v uint16) { gpos = be.AppendUint16(gpos, v) }
+
+	// Header: version 1.0, then ScriptList at 10, FeatureList at 30 and
+	// LookupList at 44.
+	u16(1)
+	u16(0)
+	u16(10)
+	u16(30)
+	u16(44)
+	// ScriptList: latn, whose default LangSys enables feature 0.
+	u16(1)
+	gpos = append(gpos, "latn"...)
+	u16(8)
+	u16(4) // Script: defaultLangSys offset
+	u16(0)
+	u16(0) // LangSys: lookupOrder
+	u16(0xffff)
+	u16(1)
+	u16(0)
+	// FeatureList: kern, using lookup 0.
+	u16(1)
+	gpos = append(gpos, "kern"...)
+	u16(8)
+	u16(0) // Feature: featureParams
+	u16(1)
+	u16(0)
+	// LookupList with one PairPos lookup and one subtable.
+	u16(1)
+	u16(4)
+	u16(2) // Lookup: type
+	u16(0)
+	u16(1)
+	u16(8)
+	// PairPos format 1 adjusting the first glyph's x advance.
+	u16(1)
+	u16(12) // coverage offset
+	u16(0x0004)
+	u16(0)
+	u16(1)
+	u16(18) // pair set offset
+	u16(1)  // Coverage format 1
+	u16(1)
+	u16(uint16(first))
+	u16(1) // PairSet
+	u16(uint16(second))
+	u16(uint16(xAdvance))
+
+	return withTables(data, map[string][]byte{"GPOS": gpos})
+}
+
+// withTables returns a copy of the font data with extra tables added, or
+// replacing those with the same tag, keeping the table directory sorted.
+func withTables(data []byte, extra map[string][]byte) []byte {
+	be := binary.BigEndian
+	type table struct {
+		tag  string
+		body []byte
+	}
+	numTables := int(be.Uint16(data[4:]))
+	var tables []table
+	for tag, body := range extra {
+		tables = append(tables, table{tag, body})
+	}
+	for i := range numTables {
+		rec := data[12+16*i:]
+		if _, ok := extra[string(rec[:4])]; ok {
+			continue
+		}
+		off, n := be.Uint32(rec[8:]), be.Uint32(rec[12:])
+		tables = append(tables, table{string(rec[:4]), data[off : off+n]})
+	}
+	slices.SortFunc(tables, func(a, b table) int {
+		if a.tag < b.tag {
+			return -1
+		}
+		return 1
+	})
+
+	out := append([]byte(nil), data[:12]...)
+	be.PutUint16(out[4:], uint16(len(tables)))
+	offset := 12 + 16*len(tables)
+	for _, t := range tables {
+		out = append(out, t.tag...)
+		out = be.AppendUint32(out, 0)
+		out = be.AppendUint32(out, uint32(offset))
+		out = be.AppendUint32(out, uint32(len(t.body)))
+		offset += (len(t.body) + 3) &^ 3
+	}
+	for _, t := range tables {
+		out = append(out, t.body...)
+		out = append(out, make([]byte, (4-len(t.body)%4)%4)...)
+	}
+	return out
+}
+
+func TestGPOSKerning(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	// Go Regular has neither a kern nor a GPOS table.
+	plain, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	f := fs.Fonts[plain]
+	tIndex := sfnt.GlyphIndex(fs.getGlyphIndex(f, 'T'))
+	oIndex := sfnt.GlyphIndex(fs.getGlyphIndex(f, 'o'))
+	if k := fs.Kerning(plain, 'T', 'o', 40); k != 0 {
+		t.Fatalf("Expected no kerning without kerning tables, got %v", k)
+	}
+
+	kerned, err := fs.AddFontFromBytes("go-gpos", withGPOSKern(goregular.TTF, tIndex, oIndex, -256))
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	// -256 units of a 2048 unit em is -5px at 40px.
+	if k := fs.Kerning(kerned, 'T', 'o', 40); k != -5 {
+		t.Errorf("Expected GPOS kerning of -5px for To, got %v", k)
+	}
+	if k := fs.Kerning(kerned, 'o', 'T', 40); k != 0 {
+		t.Errorf("Expected no kerning for oT, got %v", k)
+	}
+
+	fs.SetSize(40)
+	fs.SetFont(plain)
+	loose := fs.TextBounds(0, 0, "To", nil)
+	fs.SetFont(kerned)
+	if tight := fs.TextBounds(0, 0, "To", nil); tight != loose-5 {
+		t.Errorf("Expected DrawText to apply GPOS kerning, widths %v and %v", loose, tight)
+	}
+}
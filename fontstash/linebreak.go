@@ -0,0 +1,64 @@
+package fontstash
+
+import "unicode"
+
+// LineBreaker decides where text may wrap. The wrapping routines call
+// CanBreakBefore for each pair of adjacent runes and only start a new line
+// before cur when it returns true.
+type LineBreaker interface {
+	CanBreakBefore(prev, cur rune) bool
+}
+
+// WhitespaceBreaker allows a break after ASCII whitespace, which suits
+// text where words are separated by spaces. It is the default when
+// Params.LineBreaker is nil.
+type WhitespaceBreaker struct{}
+
+// CanBreakBefore implements LineBreaker.
+func (WhitespaceBreaker) CanBreakBefore(prev, cur rune) bool {
+	return isSpace(prev) && !isSpace(cur)
+}
+
+// CJKBreaker extends WhitespaceBreaker for mixed scripts: it also allows a
+// break on either side of a CJK ideograph, kana or Hangul syllable, and
+// after a hyphen or slash so long URLs and paths can wrap. Breaks are not
+// allowed before closing punctuation such as "、" or ")".
+type CJKBreaker struct{}
+
+// CanBreakBefore implements LineBreaker.
+func (CJKBreaker) CanBreakBefore(prev, cur rune) bool {
+	if isSpace(cur) || isClosing(cur) {
+		return false
+	}
+	if isSpace(prev) || prev == '-' || prev == '/' {
+		return true
+	}
+	return (isCJK(prev) && !isOpening(prev)) || isCJK(cur)
+}
+
+// lineBreaker returns the configured LineBreaker or the default.
+func (fs *FontStash) lineBreaker() LineBreaker {
+	if fs.Params.LineBreaker != nil {
+		return fs.Params.LineBreaker
+	}
+	return WhitespaceBreaker{}
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\v'
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303f) || // CJK symbols and punctuation
+		(r >= 0xff00 && r <= 0xffef) // Halfwidth and fullwidth forms
+}
+
+func isClosing(r rune) bool {
+	return unicode.In(r, unicode.Pe, unicode.Pf) || r == '、' || r == '。' || r == '，' || r == '．' ||
+		r == '!' || r == '?' || r == ',' || r == '.' || r == ':' || r == ';' || r == '！' || r == '？'
+}
+
+func isOpening(r rune) bool {
+	return unicode.In(r, unicode.Ps, unicode.Pi)
+}
@@ -0,0 +1,50 @@
+package fontstash
+
+import "testing"
+
+func TestLineBreakers(t *testing.T) {
+	breaks := func(lb LineBreaker, s string) []int {
+		var at []int
+		runes := []rune(s)
+		for i := 1; i < len(runes); i++ {
+			if lb.CanBreakBefore(runes[i-1], runes[i]) {
+				at = append(at, i)
+			}
+		}
+		return at
+	}
+	tests := []struct {
+		name string
+		lb   LineBreaker
+		s    string
+		want []int
+	}{
+		{"words", WhitespaceBreaker{}, "ab cd  ef", []int{3, 7}},
+		{"no cjk breaks by default", WhitespaceBreaker{}, "日本語", nil},
+		{"ideographs", CJKBreaker{}, "日本語", []int{1, 2}},
+		{"closing punctuation", CJKBreaker{}, "日本。語", []int{1, 3}},
+		{"mixed", CJKBreaker{}, "ab日cd", []int{2, 3}},
+		{"url", CJKBreaker{}, "a.com/b-c", []int{6, 8}},
+	}
+	for _, tt := range tests {
+		got := breaks(tt.lb, tt.s)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected breaks %v, got %v", tt.name, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected breaks %v, got %v", tt.name, tt.want, got)
+				break
+			}
+		}
+	}
+
+	fs, err := New(Params{Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	if _, ok := fs.lineBreaker().(WhitespaceBreaker); !ok {
+		t.Errorf("Expected WhitespaceBreaker by default")
+	}
+}
@@ -0,0 +1,123 @@
+package fontstash
+
+import "image"
+
+// PageRenderer is implemented by renderers that can hold more than one
+// atlas texture, which Params.MaxAtlasPages above 1 requires. Page 0 is the
+// texture Resize and Update manage; AddPage creates texture page, numbered
+// from 1, of the given size, and UpdatePage uploads a region of it as
+// Update does. After ResetAtlas the extra pages are dropped, and AddPage
+// may be called again for a page number, replacing its texture. Batches
+// passed to DrawBatch name the page their vertices sample in Texture.
+type PageRenderer interface {
+	BatchRenderer
+	AddPage(page, width, height int)
+	UpdatePage(page int, rect image.Rectangle, data []byte, stride int)
+}
+
+// atlasPage is an atlas texture after the first, with its own packer and
+// dirty region. Pages share the first page's size.
+type atlasPage struct {
+	atlas *Atlas
+	tex   []byte
+	dirty image.Rectangle
+}
+
+// addCell finds room for a w by h cell on an atlas page.
+func (fs *FontStash) addCell(w, h int) (page, x, y int, ok bool) {
+	if x, y, ok = fs.Atlas.addRect(w, h); ok {
+		return 0, x, y, true
+	}
+	for i := range fs.pages {
+		if x, y, ok = fs.pages[i].atlas.addRect(w, h); ok {
+			return i + 1, x, y, true
+		}
+	}
+	if fs.addPage() {
+		if x, y, ok = fs.pages[len(fs.pages)-1].atlas.addRect(w, h); ok {
+			return len(fs.pages), x, y, true
+		}
+	}
+
+	if fs.Params.ErrorCallback != nil {
+		fs.Params.ErrorCallback(ErrAtlasFull)
+	}
+	// The C code calls the handler and tries again; it may have made room.
+	x, y, ok = fs.Atlas.addRect(w, h)
+	return 0, x, y, ok
+}
+
+// addPage appends an empty atlas page, reporting false if
+// Params.MaxAtlasPages is reached or the renderer can't hold pages.
+func (fs *FontStash) addPage() bool {
+	pr, ok := fs.Params.Renderer.(PageRenderer)
+	if !ok || len(fs.pages)+1 >= fs.Params.MaxAtlasPages {
+		return false
+	}
+	fs.pages = append(fs.pages, atlasPage{
+		atlas: newAtlas(fs.Width, fs.Height, fs.Params.AtlasNodeCapacity),
+		tex:   make([]byte, fs.Width*fs.Height),
+		dirty: image.Rectangle{Min: image.Point{fs.Width, fs.Height}},
+	})
+	pr.AddPage(len(fs.pages), fs.Width, fs.Height)
+	return true
+}
+
+// pageTex returns the texture of atlas page.
+func (fs *FontStash) pageTex(page int) []byte {
+	if page == 0 {
+		return fs.TexData
+	}
+	return fs.pages[page-1].tex
+}
+
+// markPageDirty is markDirty for any atlas page.
+func (fs *FontStash) markPageDirty(page, x, y, w, h int) {
+	if page == 0 {
+		fs.markDirty(x, y, w, h)
+		return
+	}
+	d := &fs.pages[page-1].dirty
+	d.Min.X = min(d.Min.X, x)
+	d.Min.Y = min(d.Min.Y, y)
+	d.Max.X = max(d.Max.X, x+w)
+	d.Max.Y = max(d.Max.Y, y+h)
+}
+
+// flushPages uploads the dirty regions of the pages after the first.
+func (fs *FontStash) flushPages() {
+	pr, ok := fs.Params.Renderer.(PageRenderer)
+	bounds := image.Rect(0, 0, fs.Width, fs.Height)
+	for i := range fs.pages {
+		p := &fs.pages[i]
+		if dirty := p.dirty.Intersect(bounds); ok && !dirty.Empty() {
+			pr.UpdatePage(i+1, dirty, p.tex, fs.Width)
+		}
+		p.dirty = image.Rectangle{Min: image.Point{fs.Width, fs.Height}}
+	}
+}
+
+// AtlasPages returns how many atlas pages are in use, at least 1 unless
+// measuring only. See Params.MaxAtlasPages.
+func (fs *FontStash) AtlasPages() int {
+	if fs.Params.MeasureOnly {
+		return 0
+	}
+	return 1 + len(fs.pages)
+}
+
+// AtlasPageImage is AtlasImage for any atlas page, or nil if there is no
+// such page.
+func (fs *FontStash) AtlasPageImage(page int) *image.Alpha {
+	if page == 0 {
+		return fs.AtlasImage()
+	}
+	if page < 0 || page >= fs.AtlasPages() {
+		return nil
+	}
+	return &image.Alpha{
+		Pix:    fs.pageTex(page),
+		Stride: fs.Width,
+		Rect:   image.Rect(0, 0, fs.Width, fs.Height),
+	}
+}
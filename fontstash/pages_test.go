@@ -0,0 +1,119 @@
+package fontstash
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+type pageRenderer struct {
+	textureRenderer
+	Added   []int
+	Updated map[int]int
+}
+
+func (r *pageRenderer) AddPage(page, width, height int) {
+	r.Added = append(r.Added, page)
+}
+
+func (r *pageRenderer) UpdatePage(page int, rect image.Rectangle, data []byte, stride int) {
+	if r.Updated == nil {
+		r.Updated = make(map[int]int)
+	}
+	r.Updated[page]++
+}
+
+func TestAtlasPages(t *testing.T) {
+	rec := &pageRenderer{}
+	fs, err := New(Params{Width: 64, Height: 64, Renderer: rec, MaxAtlasPages: 3})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	// One 64x64 page holds only a few glyphs at this size.
+	const str = "ABCDEFGHIJKLMNOP"
+	fs.DrawText(0, 30, str)
+	if fs.AtlasPages() < 2 || len(rec.Added) != fs.AtlasPages()-1 || rec.Added[0] != 1 {
+		t.Fatalf("Expected extra pages to be added, got %d pages and AddPage calls %v", fs.AtlasPages(), rec.Added)
+	}
+	for page := 1; page < fs.AtlasPages(); page++ {
+		if rec.Updated[page] == 0 {
+			t.Errorf("Expected page %d to be uploaded", page)
+		}
+	}
+
+	// Each batch samples the page its glyphs are on.
+	if len(rec.Batches) != 1 {
+		t.Fatalf("Expected one DrawBatch call, got %d", len(rec.Batches))
+	}
+	var glyphs []Glyph
+	for _, r := range str {
+		_, g := fs.Fonts[fontNormal].findGlyph(r, 200, 0, 0)
+		glyphs = append(glyphs, *g)
+	}
+	i := 0
+	for _, b := range rec.Batches[0] {
+		for range len(b.Vertices) / vertsPerQuad {
+			if int(glyphs[i].Page) != b.Texture {
+				t.Errorf("Glyph %q on page %d drawn in a batch for page %d", str[i], glyphs[i].Page, b.Texture)
+			}
+			i++
+		}
+	}
+	if i != len(str) || len(rec.Batches[0]) < 2 {
+		t.Errorf("Expected %d quads over several batches, got %d in %d", len(str), i, len(rec.Batches[0]))
+	}
+	if draws, _ := fs.DrawStats(); draws != uint64(len(rec.Batches[0])) {
+		t.Errorf("Expected DrawStats to count %d batches, got %d", len(rec.Batches[0]), draws)
+	}
+
+	// The bitmap of a glyph on a later page comes from that page.
+	last := glyphs[len(glyphs)-1]
+	img, _, err := fs.GlyphBitmap(fontNormal, rune(str[len(str)-1]), 20, 0)
+	if err != nil || last.Page == 0 {
+		t.Fatalf("Expected the last glyph on a later page, got page %d and %v", last.Page, err)
+	}
+	page := fs.AtlasPageImage(int(last.Page))
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.AlphaAt(x, y) != page.AlphaAt(int(last.X0)+x, int(last.Y0)+y) {
+				t.Fatalf("Bitmap differs from page %d at %d, %d", last.Page, x, y)
+			}
+		}
+	}
+
+	if fs.ExpandAtlas(128, 128) || fs.RepackAtlas() {
+		t.Errorf("Expected ExpandAtlas and RepackAtlas to fail with several pages")
+	}
+
+	var buf bytes.Buffer
+	if err := fs.SaveAtlas(&buf); err != nil {
+		t.Fatalf("SaveAtlas: %v", err)
+	}
+	pages := fs.AtlasPages()
+	fs.ResetAtlas(64, 64)
+	if fs.AtlasPages() != 1 {
+		t.Errorf("Expected ResetAtlas to drop the extra pages, got %d", fs.AtlasPages())
+	}
+	if err := fs.LoadAtlas(&buf); err != nil {
+		t.Fatalf("LoadAtlas: %v", err)
+	}
+	if fs.AtlasPages() != pages {
+		t.Errorf("Expected %d pages after LoadAtlas, got %d", pages, fs.AtlasPages())
+	}
+
+	// Once every page is full the atlas reports ErrAtlasFull.
+	if err := fs.CacheGlyphRange('a', 'z'); err != ErrAtlasFull {
+		t.Errorf("Expected ErrAtlasFull with every page full, got %v", err)
+	}
+	if fs.AtlasPages() != 3 {
+		t.Errorf("Expected the page limit to be reached, got %d", fs.AtlasPages())
+	}
+}
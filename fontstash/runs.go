@@ -0,0 +1,87 @@
+package fontstash
+
+// TextRun is a span of rich text drawn by DrawRuns with its own font,
+// size, blur, color and letter spacing in pixels.
+type TextRun struct {
+	Text       string
+	Font       int
+	Size, Blur float32
+	Color      uint32
+	Spacing    float32
+}
+
+// DrawRuns draws runs one after another on a shared baseline in a single
+// batch, carrying the pen from each run into the next, and returns the pen
+// x after the last run. Kerning continues between adjacent runs of the same
+// font and is skipped where the font changes, as at a fallback boundary.
+//
+// Horizontal alignment, decorations, clipping and the transform come from
+// the current state and apply to the runs as a whole; vertical alignment
+// and the decoration lines use the first run's font, size and color. Runs
+// are always laid out left to right. The current state is left unchanged.
+// Runs with an invalid font are skipped.
+func (fs *FontStash) DrawRuns(x, y float32, runs []TextRun) float32 {
+	state := fs.getState()
+	saved := *state
+	defer func() { *state = saved }()
+
+	first := -1
+	for i, run := range runs {
+		if f := fs.getFont(run.Font); f != nil && f.Data != nil {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return x
+	}
+	f := fs.getFont(runs[first].Font)
+	y = fs.snapBaseline(state, y+fs.getVertAlign(f, state.Align, fs.sizeKey(runs[first].Size*state.scaleY())))
+
+	if state.Align&AlignLeft != 0 {
+		// empty
+	} else if state.Align&AlignRight != 0 {
+		x -= fs.layoutRuns(state, 0, y, runs, false)
+	} else if state.Align&AlignCenter != 0 {
+		x -= fs.layoutRuns(state, 0, y, runs, false) * 0.5
+	}
+
+	startX := x
+	x = fs.layoutRuns(state, x, y, runs, true)
+	if state.Decoration != 0 {
+		state.Size = fs.clampSize(runs[first].Size)
+		state.Color = runs[first].Color
+		fs.drawDecorations(f, state, startX, x, y)
+	}
+	fs.flush()
+	return x
+}
+
+// layoutRuns lays runs out from x, returning the end x; it overwrites state.
+func (fs *FontStash) layoutRuns(state *State, x, y float32, runs []TextRun, draw bool) float32 {
+	var prevGlyph *Glyph
+	xf := state.vertexTransform()
+	for _, run := range runs {
+		f := fs.getFont(run.Font)
+		if f == nil || f.Data == nil {
+			continue
+		}
+		state.Font = run.Font
+		state.Size = fs.clampSize(run.Size)
+		state.Blur = run.Blur
+		state.Color = run.Color
+		state.Spacing = run.Spacing
+		state.SpacingEm = 0
+
+		color := fs.vertexColor(run.Color)
+		l := fs.newLayout(state, f, fs.normalized(runeIter{s: run.Text}), x, y, false)
+		l.prev = prevGlyph
+		for l.next() {
+			if draw && l.inked() {
+				fs.emitQuad(state, xf, &l.q, l.page, color)
+			}
+		}
+		x, prevGlyph = l.x, l.prev
+	}
+	return x
+}
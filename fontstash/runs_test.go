@@ -0,0 +1,124 @@
+package fontstash
+
+import (
+	"testing"
+
+	"golang.org/x/image/font/gofont/gomono"
+)
+
+func TestDrawRuns(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: rec})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	serif, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	mono, err := fs.AddFontFromBytes("mono", gomono.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(serif)
+	fs.SetSize(20)
+	fs.SetColor(0xff00ff00)
+
+	// Splitting a string into runs of the same font keeps its kerning.
+	const red, blue = 0xff0000ff, 0xffff0000
+	whole := fs.DrawText(10, 50, "AVAVA")
+	rec.Vertices = rec.Vertices[:0]
+	draws := rec.Draws
+	x := fs.DrawRuns(10, 50, []TextRun{
+		{Text: "AV", Font: serif, Size: 20, Color: red},
+		{Text: "AVA", Font: serif, Size: 20, Color: blue},
+	})
+	if x != whole {
+		t.Errorf("Expected runs to end at %v like DrawText, got %v", whole, x)
+	}
+	if rec.Draws != draws+1 {
+		t.Errorf("Expected the runs in one batch, got %d draws", rec.Draws-draws)
+	}
+	if len(rec.Vertices) != 5*vertsPerQuad {
+		t.Fatalf("Expected %d verts, got %d", 5*vertsPerQuad, len(rec.Vertices))
+	}
+	for i, v := range rec.Vertices {
+		want := uint32(red)
+		if i >= 2*vertsPerQuad {
+			want = blue
+		}
+		if v.Color != want {
+			t.Fatalf("Vertex %d color = %#x, want %#x", i, v.Color, want)
+		}
+	}
+	if s := fs.getState(); s.Font != serif || s.Size != 20 || s.Color != 0xff00ff00 {
+		t.Errorf("Expected the state to be kept, got %+v", *s)
+	}
+
+	// Kerning is skipped where the font changes.
+	x = fs.DrawRuns(10, 50, []TextRun{
+		{Text: "A", Font: serif, Size: 20},
+		{Text: "V", Font: mono, Size: 30},
+	})
+	fs.SetFont(serif)
+	a := fs.TextBounds(0, 0, "A", nil)
+	fs.SetFont(mono)
+	fs.SetSize(30)
+	v := fs.TextBounds(0, 0, "V", nil)
+	if want := 10 + a + v; x != want {
+		t.Errorf("Expected mixed runs to end at %v, got %v", want, x)
+	}
+
+	fs.SetFont(serif)
+	fs.SetSize(20)
+	fs.SetAlign(AlignRight | AlignBaseline)
+	if x := fs.DrawRuns(200, 50, []TextRun{{Text: "AV", Font: serif, Size: 20}, {Text: "AVA", Font: serif, Size: 20}}); x != 200 {
+		t.Errorf("Expected right aligned runs to end at 200, got %v", x)
+	}
+}
+
+func TestDrawRunsDecorations(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 512, Height: 512, Renderer: rec})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	serif, err := fs.AddFont("serif", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	mono, err := fs.AddFontFromBytes("mono", gomono.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetDecoration(DecorationUnderline)
+
+	const red, blue = 0xff0000ff, 0xffff0000
+	x := fs.DrawRuns(10, 50, []TextRun{
+		{Text: "ab", Font: serif, Size: 20, Color: red},
+		{Text: "cd", Font: mono, Size: 30, Color: blue},
+	})
+	if len(rec.Vertices) != 5*vertsPerQuad {
+		t.Fatalf("Expected 4 glyphs and 1 line, got %d verts", len(rec.Vertices))
+	}
+	underline := rec.Vertices[4*vertsPerQuad:]
+	u, v := fs.WhiteRectUV()
+	for _, vert := range underline {
+		if vert.U != u || vert.V != v || vert.Color != red {
+			t.Fatalf("Unexpected decoration vertex %+v", vert)
+		}
+	}
+	if underline[0].X != 10 || underline[1].X != x {
+		t.Errorf("Expected underline to span 10 to %v, got %v to %v", x, underline[0].X, underline[1].X)
+	}
+
+	// The line matches the one DrawText gives the first run's font.
+	top := underline[0].Y
+	rec.Vertices = nil
+	fs.SetFont(serif)
+	fs.SetSize(20)
+	fs.DrawText(10, 50, "ab")
+	if got, want := top, rec.Vertices[2*vertsPerQuad].Y; got != want {
+		t.Errorf("Expected the underline at %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,153 @@
+package fontstash
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"image"
+	"io"
+)
+
+const snapshotVersion = 2
+
+type atlasSnapshot struct {
+	Version       int
+	Width, Height int
+	TexData       []byte
+	Nodes         []snapshotNode
+	Fonts         []fontSnapshot
+	Pages         []pageSnapshot // Atlas pages after the first
+	WhiteRect     image.Rectangle
+}
+
+type pageSnapshot struct {
+	TexData []byte
+	Nodes   []snapshotNode
+}
+
+type snapshotNode struct {
+	X, Y, Width int16
+}
+
+type fontSnapshot struct {
+	Hash   [sha256.Size]byte
+	Index  int // Face index, as faces of a collection share its data
+	Glyphs []Glyph
+	Lut    []int
+}
+
+// SaveAtlas writes the atlas texture, packing state and every font's glyph
+// cache to w, so a warmed cache can be shipped with an application and
+// restored with LoadAtlas.
+func (fs *FontStash) SaveAtlas(w io.Writer) error {
+	snap := atlasSnapshot{
+		Version:   snapshotVersion,
+		Width:     fs.Width,
+		Height:    fs.Height,
+		TexData:   fs.TexData,
+		Nodes:     snapshotNodes(fs.Atlas),
+		Fonts:     make([]fontSnapshot, len(fs.Fonts)),
+		WhiteRect: fs.whiteRect,
+	}
+	for _, p := range fs.pages {
+		snap.Pages = append(snap.Pages, pageSnapshot{TexData: p.tex, Nodes: snapshotNodes(p.atlas)})
+	}
+	for i, f := range fs.Fonts {
+		if f == nil {
+			// Removed fonts keep their slot with a zero hash.
+			continue
+		}
+		snap.Fonts[i] = fontSnapshot{
+			Hash:   sha256.Sum256(f.Data),
+			Index:  f.index,
+			Glyphs: f.Glyphs,
+			Lut:    f.Lut,
+		}
+	}
+	return gob.NewEncoder(w).Encode(&snap)
+}
+
+// LoadAtlas restores a snapshot written by SaveAtlas. The same fonts must
+// have been added in the same order and the atlas must have the same
+// dimensions and white rect, otherwise ErrAtlasMismatch is returned and
+// the current atlas is left untouched. The whole texture is marked dirty so it is uploaded
+// on the next draw.
+func (fs *FontStash) LoadAtlas(r io.Reader) error {
+	var snap atlasSnapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrAtlasMismatch, snap.Version)
+	}
+	if snap.Width != fs.Width || snap.Height != fs.Height || len(snap.TexData) != len(fs.TexData) {
+		return fmt.Errorf("%w: atlas is %dx%d, snapshot is %dx%d", ErrAtlasMismatch, fs.Width, fs.Height, snap.Width, snap.Height)
+	}
+	if snap.WhiteRect != fs.whiteRect {
+		return fmt.Errorf("%w: white rect is %v, snapshot has %v", ErrAtlasMismatch, fs.whiteRect, snap.WhiteRect)
+	}
+	if len(snap.Pages) > 0 {
+		if _, ok := fs.Params.Renderer.(PageRenderer); !ok || len(snap.Pages) >= fs.Params.MaxAtlasPages {
+			return fmt.Errorf("%w: snapshot has %d atlas pages", ErrAtlasMismatch, len(snap.Pages)+1)
+		}
+	}
+	if len(snap.Fonts) != len(fs.Fonts) {
+		return fmt.Errorf("%w: %d fonts loaded, snapshot has %d", ErrAtlasMismatch, len(fs.Fonts), len(snap.Fonts))
+	}
+	for i, f := range fs.Fonts {
+		if f == nil {
+			if snap.Fonts[i].Hash != ([sha256.Size]byte{}) {
+				return fmt.Errorf("%w: font %d was removed", ErrAtlasMismatch, i)
+			}
+			continue
+		}
+		if sha256.Sum256(f.Data) != snap.Fonts[i].Hash || f.index != snap.Fonts[i].Index {
+			return fmt.Errorf("%w: font %d (%s) differs", ErrAtlasMismatch, i, f.Name)
+		}
+		if len(snap.Fonts[i].Lut) != len(f.Lut) {
+			return fmt.Errorf("%w: font %d lookup table size differs", ErrAtlasMismatch, i)
+		}
+	}
+
+	fs.flush()
+
+	copy(fs.TexData, snap.TexData)
+	restoreNodes(fs.Atlas, snap.Nodes)
+	fs.pages = fs.pages[:0]
+	for _, p := range snap.Pages {
+		if !fs.addPage() {
+			break
+		}
+		page := &fs.pages[len(fs.pages)-1]
+		copy(page.tex, p.TexData)
+		restoreNodes(page.atlas, p.Nodes)
+		page.dirty = image.Rect(0, 0, fs.Width, fs.Height)
+	}
+	for i, f := range fs.Fonts {
+		if f == nil {
+			continue
+		}
+		f.Glyphs = append(f.Glyphs[:0], snap.Fonts[i].Glyphs...)
+		copy(f.Lut, snap.Fonts[i].Lut)
+	}
+	fs.Dirty = image.Rect(0, 0, fs.Width, fs.Height)
+
+	return nil
+}
+
+// snapshotNodes copies the skyline of a.
+func snapshotNodes(a *Atlas) []snapshotNode {
+	nodes := make([]snapshotNode, len(a.nodes))
+	for i, n := range a.nodes {
+		nodes[i] = snapshotNode{X: n.x, Y: n.y, Width: n.width}
+	}
+	return nodes
+}
+
+// restoreNodes replaces the skyline of a with nodes.
+func restoreNodes(a *Atlas, nodes []snapshotNode) {
+	a.nodes = a.nodes[:0]
+	for _, n := range nodes {
+		a.nodes = append(a.nodes, atlasNode{x: n.X, y: n.Y, width: n.Width})
+	}
+}
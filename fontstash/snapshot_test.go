@@ -0,0 +1,76 @@
+package fontstash
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestSaveLoadAtlas(t *testing.T) {
+	newStash := func(width int) (*FontStash, int) {
+		fs, err := New(Params{Width: width, Height: 256, Renderer: &MockRenderer{}})
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+		if err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		fs.SetFont(fontNormal)
+		fs.SetSize(20)
+		return fs, fontNormal
+	}
+
+	src, _ := newStash(256)
+	src.DrawText(0, 0, "Snapshot")
+	var buf bytes.Buffer
+	if err := src.SaveAtlas(&buf); err != nil {
+		t.Fatalf("SaveAtlas: %v", err)
+	}
+	data := buf.Bytes()
+
+	dst, fontNormal := newStash(256)
+	if err := dst.LoadAtlas(bytes.NewReader(data)); err != nil {
+		t.Fatalf("LoadAtlas: %v", err)
+	}
+	if !bytes.Equal(dst.TexData, src.TexData) {
+		t.Errorf("Expected texture data to be restored")
+	}
+	cached := len(dst.Fonts[fontNormal].Glyphs)
+	dst.DrawText(0, 0, "Snapshot")
+	if got := len(dst.Fonts[fontNormal].Glyphs); got != cached {
+		t.Errorf("Expected restored glyphs to be reused, cache grew from %d to %d", cached, got)
+	}
+
+	wrongSize, _ := newStash(512)
+	if err := wrongSize.LoadAtlas(bytes.NewReader(data)); !errors.Is(err, ErrAtlasMismatch) {
+		t.Errorf("Expected ErrAtlasMismatch for different dimensions, got %v", err)
+	}
+
+	for _, params := range []Params{{NoWhiteRect: true}, {WhiteRectSize: 4}} {
+		params.Width, params.Height, params.Renderer = 256, 256, &MockRenderer{}
+		wrongRect, err := New(params)
+		if err != nil {
+			t.Fatalf("Failed to create fontstash: %v", err)
+		}
+		if _, err := wrongRect.AddFont("sans", "testdata/DroidSerif-Regular.ttf"); err != nil {
+			t.Fatalf("Failed to load font: %v", err)
+		}
+		if err := wrongRect.LoadAtlas(bytes.NewReader(data)); !errors.Is(err, ErrAtlasMismatch) {
+			t.Errorf("Expected ErrAtlasMismatch for NoWhiteRect %v and WhiteRectSize %d, got %v", params.NoWhiteRect, params.WhiteRectSize, err)
+		}
+	}
+
+	wrongFont, err := New(Params{Width: 256, Height: 256})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	if _, err := wrongFont.AddFontFromBytes("go", goregular.TTF); err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	if err := wrongFont.LoadAtlas(bytes.NewReader(data)); !errors.Is(err, ErrAtlasMismatch) {
+		t.Errorf("Expected ErrAtlasMismatch for different fonts, got %v", err)
+	}
+}
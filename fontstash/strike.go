@@ -0,0 +1,298 @@
+package fontstash
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+)
+
+// strikeTables holds a font's embedded bitmap strikes, read from its EBLC
+// and EBDT tables. sfnt doesn't decode them, so the tables are parsed
+// here. Only 1 bit per pixel strikes are used.
+type strikeTables struct {
+	eblc, ebdt []byte
+	sizes      []strikeSize
+	outlines   bool // Whether the font has outlines to fall back on
+}
+
+// strikeSize is one strike: the glyphs of one pixel size.
+type strikeSize struct {
+	ppem       int
+	array      int // Offset of the index subtable array in EBLC
+	subtables  int
+	start, end int // Glyph index range covered
+}
+
+// parseStrikes reads the font's monochrome bitmap strikes, or returns nil.
+func parseStrikes(data []byte, offset int) *strikeTables {
+	eblc, ebdt := fontTable(data, offset, "EBLC"), fontTable(data, offset, "EBDT")
+	if len(eblc) < 8 || len(ebdt) < 4 {
+		return nil
+	}
+	be := binary.BigEndian
+	// Bitmap-only fonts still carry an empty glyf table for parsers that
+	// require one.
+	outlines := len(fontTable(data, offset, "glyf")) > 0 || len(fontTable(data, offset, "CFF ")) > 0
+	s := &strikeTables{eblc: eblc, ebdt: ebdt, outlines: outlines}
+	numSizes := int(be.Uint32(eblc[4:]))
+	for i := range numSizes {
+		rec := eblc[min(len(eblc), 8+48*i):]
+		if len(rec) < 48 {
+			break
+		}
+		if rec[46] != 1 || rec[44] != rec[45] {
+			// Grayscale, or pixels that aren't square.
+			continue
+		}
+		s.sizes = append(s.sizes, strikeSize{
+			ppem:      int(rec[45]),
+			array:     int(be.Uint32(rec)),
+			subtables: int(be.Uint32(rec[8:])),
+			start:     int(be.Uint16(rec[40:])),
+			end:       int(be.Uint16(rec[42:])),
+		})
+	}
+	if len(s.sizes) == 0 {
+		return nil
+	}
+	return s
+}
+
+// fontTable returns the body of the table tag in the font whose table
+// directory starts at offset in data, or nil.
+func fontTable(data []byte, offset int, tag string) []byte {
+	be := binary.BigEndian
+	if offset < 0 || len(data) < offset+12 {
+		return nil
+	}
+	numTables := int(be.Uint16(data[offset+4:]))
+	for i := range numTables {
+		rec := data[min(len(data), offset+12+16*i):]
+		if len(rec) < 16 {
+			return nil
+		}
+		if string(rec[:4]) == tag {
+			off, n := int64(be.Uint32(rec[8:])), int64(be.Uint32(rec[12:]))
+			if off+n > int64(len(data)) {
+				return nil
+			}
+			return data[off : off+n]
+		}
+	}
+	return nil
+}
+
+// glyph returns glyph index from the strike nearest px, if it has it.
+func (s *strikeTables) glyph(index int, px float64) (dr image.Rectangle, mask *image.Alpha, advance int, ok bool) {
+	b, format, metrics, ok := s.find(index, px)
+	if !ok {
+		return
+	}
+	return decodeStrikeImage(b, format, metrics)
+}
+
+// advance returns the advance in pixels of the glyph that glyph would
+// return, reading only its metrics.
+func (s *strikeTables) advance(index int, px float64) (int, bool) {
+	b, format, metrics, ok := s.find(index, px)
+	if !ok {
+		return 0, false
+	}
+	metrics, _, _, ok = imageHeader(b, format, metrics)
+	return metrics.advance, ok
+}
+
+// find returns the EBDT image, format and metrics of glyph index for px.
+func (s *strikeTables) find(index int, px float64) (b []byte, format uint16, metrics glyphMetrics, ok bool) {
+	if s == nil {
+		return
+	}
+	ppem := int(math.Round(px))
+	best := -1
+	for i, size := range s.sizes {
+		if index < size.start || index > size.end {
+			continue
+		}
+		if size.ppem == ppem {
+			best = i
+			break
+		}
+		if !s.outlines && (best < 0 || abs(size.ppem-ppem) < abs(s.sizes[best].ppem-ppem)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return
+	}
+	return s.sizeGlyph(s.sizes[best], index)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// glyphMetrics are the horizontal metrics of a bitmap glyph, in pixels.
+type glyphMetrics struct {
+	width, height      int
+	bearingX, bearingY int
+	advance            int
+}
+
+// readMetrics reads big metrics, or small metrics, which are laid out as
+// their first five bytes.
+func readMetrics(b []byte) glyphMetrics {
+	return glyphMetrics{
+		height:   int(b[0]),
+		width:    int(b[1]),
+		bearingX: int(int8(b[2])),
+		bearingY: int(int8(b[3])),
+		advance:  int(b[4]),
+	}
+}
+
+// sizeGlyph finds glyph index among the index subtables of size and
+// returns its image as find does.
+func (s *strikeTables) sizeGlyph(size strikeSize, index int) (b []byte, format uint16, metrics glyphMetrics, ok bool) {
+	be := binary.BigEndian
+	eblc := s.eblc
+	for i := range size.subtables {
+		entry := eblc[min(len(eblc), size.array+8*i):]
+		if len(entry) < 8 {
+			return
+		}
+		first, last := int(be.Uint16(entry)), int(be.Uint16(entry[2:]))
+		if index < first || index > last {
+			continue
+		}
+		sub := eblc[min(len(eblc), size.array+int(be.Uint32(entry[4:]))):]
+		if len(sub) < 8 {
+			return
+		}
+		indexFormat, imageFormat := be.Uint16(sub), be.Uint16(sub[2:])
+		imageData := int(be.Uint32(sub[4:]))
+		sub = sub[8:]
+
+		// Find the glyph's image in EBDT, and its metrics for the formats
+		// that keep them in EBLC.
+		var start, end int
+		n := index - first
+		switch indexFormat {
+		case 1:
+			if len(sub) < 4*(n+2) {
+				return
+			}
+			start, end = int(be.Uint32(sub[4*n:])), int(be.Uint32(sub[4*n+4:]))
+		case 3:
+			if len(sub) < 2*(n+2) {
+				return
+			}
+			start, end = int(be.Uint16(sub[2*n:])), int(be.Uint16(sub[2*n+2:]))
+		case 2:
+			if len(sub) < 12 {
+				return
+			}
+			imageSize := int(be.Uint32(sub))
+			metrics = readMetrics(sub[4:])
+			start, end = n*imageSize, (n+1)*imageSize
+		case 4:
+			if len(sub) < 4 {
+				return
+			}
+			pairs := int(be.Uint32(sub))
+			if len(sub) < 4+4*(pairs+1) {
+				return
+			}
+			for j := range pairs {
+				p := sub[4+4*j:]
+				if int(be.Uint16(p)) == index {
+					start, end = int(be.Uint16(p[2:])), int(be.Uint16(p[6:]))
+					break
+				}
+			}
+		case 5:
+			if len(sub) < 16 {
+				return
+			}
+			imageSize := int(be.Uint32(sub))
+			metrics = readMetrics(sub[4:])
+			glyphs := int(be.Uint32(sub[12:]))
+			if len(sub) < 16+2*glyphs {
+				return
+			}
+			found := false
+			for j := range glyphs {
+				if int(be.Uint16(sub[16+2*j:])) == index {
+					start, end, found = j*imageSize, (j+1)*imageSize, true
+					break
+				}
+			}
+			if !found {
+				return
+			}
+		default:
+			return
+		}
+		if end <= start || imageData+end > len(s.ebdt) {
+			// A zero length image means the strike lacks the glyph.
+			return
+		}
+		return s.ebdt[imageData+start : imageData+end], imageFormat, metrics, true
+	}
+	return
+}
+
+// imageHeader reads the metrics and bitmap of an EBDT glyph image.
+func imageHeader(b []byte, format uint16, metrics glyphMetrics) (glyphMetrics, []byte, bool, bool) {
+	switch format {
+	case 1, 2:
+		if len(b) < 5 {
+			break
+		}
+		return readMetrics(b), b[5:], format == 2, true
+	case 6, 7:
+		if len(b) < 8 {
+			break
+		}
+		return readMetrics(b), b[8:], format == 7, true
+	case 5:
+		return metrics, b, true, true
+	}
+	return metrics, nil, false, false
+}
+
+// decodeStrikeImage decodes an EBDT glyph image of the given format into
+// an alpha mask, opaque where bits are set.
+func decodeStrikeImage(b []byte, format uint16, metrics glyphMetrics) (dr image.Rectangle, mask *image.Alpha, advance int, ok bool) {
+	metrics, b, bitAligned, ok := imageHeader(b, format, metrics)
+	if !ok {
+		return
+	}
+
+	w, h := metrics.width, metrics.height
+	stride := (w + 7) / 8
+	if bitAligned {
+		if len(b)*8 < w*h {
+			return
+		}
+	} else if len(b) < stride*h {
+		return
+	}
+	mask = image.NewAlpha(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			bit := y*stride*8 + x
+			if bitAligned {
+				bit = y*w + x
+			}
+			if b[bit/8]&(0x80>>(bit%8)) != 0 {
+				mask.Pix[y*mask.Stride+x] = 0xff
+			}
+		}
+	}
+	// Bearings are from the pen to the top left, with y up.
+	dr = image.Rect(metrics.bearingX, -metrics.bearingY, metrics.bearingX+w, -metrics.bearingY+h)
+	return dr, mask, metrics.advance, true
+}
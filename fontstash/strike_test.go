@@ -0,0 +1,154 @@
+package fontstash
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// withStrike returns a copy of Go Regular with a 16px monochrome bitmap
+// strike holding glyph a, byte aligned with its own metrics, and glyph b,
+// bit aligned with metrics in the index subtable.
+func withStrike(a, b int) []byte {
+	be := binary.BigEndian
+
+	// EBDT: a is 4x3 in image format 6, b is 3x2 in image format 5.
+	ebdt := be.AppendUint32(nil, 0x00020000)
+	aData := len(ebdt)
+	ebdt = append(ebdt, 3, 4, 1, 3, 6, 0, 0, 0)
+	ebdt = append(ebdt, 0b1001_0000, 0b0110_0000, 0b1111_0000)
+	bData := len(ebdt)
+	ebdt = append(ebdt, 0b1010_1100)
+
+	// EBLC: one strike whose index subtable array has a format 1 subtable
+	// for a and a format 2 subtable for b.
+	eblc := be.AppendUint32(nil, 0x00020000)
+	eblc = be.AppendUint32(eblc, 1)
+	const array = 8 + 48
+	eblc = be.AppendUint32(eblc, array)
+	eblc = be.AppendUint32(eblc, 48)
+	eblc = be.AppendUint32(eblc, 2)
+	eblc = be.AppendUint32(eblc, 0)
+	eblc = append(eblc, make([]byte, 24)...) // Line metrics
+	eblc = be.AppendUint16(eblc, uint16(min(a, b)))
+	eblc = be.AppendUint16(eblc, uint16(max(a, b)))
+	eblc = append(eblc, 16, 16, 1, 1)
+
+	eblc = be.AppendUint16(eblc, uint16(a))
+	eblc = be.AppendUint16(eblc, uint16(a))
+	eblc = be.AppendUint32(eblc, 16)
+	eblc = be.AppendUint16(eblc, uint16(b))
+	eblc = be.AppendUint16(eblc, uint16(b))
+	eblc = be.AppendUint32(eblc, 32)
+
+	eblc = be.AppendUint16(eblc, 1)
+	eblc = be.AppendUint16(eblc, 6)
+	eblc = be.AppendUint32(eblc, uint32(aData))
+	eblc = be.AppendUint32(eblc, 0)
+	eblc = be.AppendUint32(eblc, uint32(bData-aData))
+
+	eblc = be.AppendUint16(eblc, 2)
+	eblc = be.AppendUint16(eblc, 5)
+	eblc = be.AppendUint32(eblc, uint32(bData))
+	eblc = be.AppendUint32(eblc, 1)
+	eblc = append(eblc, 2, 3, 0, 2, 5, 0, 0, 0)
+
+	return withTables(goregular.TTF, map[string][]byte{"EBLC": eblc, "EBDT": ebdt})
+}
+
+func TestBitmapStrike(t *testing.T) {
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	plain, err := fs.AddFontFromBytes("go", goregular.TTF)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	a, b := fs.getGlyphIndex(fs.Fonts[plain], 'A'), fs.getGlyphIndex(fs.Fonts[plain], 'B')
+	data := withStrike(a, b)
+	font, err := fs.AddFontFromBytes("strike", data)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	if fs.Fonts[plain].strikes != nil || fs.Fonts[font].strikes == nil {
+		t.Fatalf("Expected only the second font to have strikes")
+	}
+
+	for _, tc := range []struct {
+		codepoint rune
+		w, h      int
+		pix       []byte
+	}{
+		{'A', 4, 3, []byte{
+			0xff, 0, 0, 0xff,
+			0, 0xff, 0xff, 0,
+			0xff, 0xff, 0xff, 0xff,
+		}},
+		{'B', 3, 2, []byte{
+			0xff, 0, 0xff,
+			0, 0xff, 0xff,
+		}},
+	} {
+		pix, w, h, err := fs.GlyphCoverage(font, tc.codepoint, 16)
+		if err != nil || w != tc.w || h != tc.h || !slices.Equal(pix, tc.pix) {
+			t.Errorf("%q: expected the %dx%d strike bitmap %v, got %dx%d %v, %v", tc.codepoint, tc.w, tc.h, tc.pix, w, h, pix, err)
+		}
+	}
+
+	// Glyphs missing from the strike, and other sizes, use the outline.
+	for _, tc := range []struct {
+		codepoint rune
+		size      float32
+	}{{'C', 16}, {'A', 17}} {
+		pix, w, _, err := fs.GlyphCoverage(font, tc.codepoint, tc.size)
+		if err != nil || w <= 4 || !slices.ContainsFunc(pix, func(a byte) bool { return a > 0 && a < 0xff }) {
+			t.Errorf("%q at %v: expected an antialiased outline, got width %d, %v", tc.codepoint, tc.size, w, err)
+		}
+	}
+
+	// A font with no outlines, only the empty glyf table bitmap fonts
+	// carry, draws its nearest strike at other sizes too.
+	loca := fontTable(data, 0, "loca")
+	bare, err := fs.AddFontFromBytes("bare", withTables(data, map[string][]byte{"glyf": {}, "loca": make([]byte, len(loca))}))
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	for _, size := range []float32{9, 24} {
+		pix, w, h, err := fs.GlyphCoverage(bare, 'B', size)
+		if err != nil || w != 3 || h != 2 || !slices.Equal(pix, []byte{0xff, 0, 0xff, 0, 0xff, 0xff}) {
+			t.Errorf("Size %v: expected the 16px strike bitmap, got %dx%d %v, %v", size, w, h, pix, err)
+		}
+	}
+	fs.SetFont(bare)
+	fs.SetSize(24)
+	if adv := fs.TextBounds(0, 0, "AB", nil); adv != 11 {
+		t.Errorf("Expected the strike's advance of 11 at 24px, got %v", adv)
+	}
+	if _, width, _, _ := fs.TextBoxBounds(0, 0, 0, "AB"); width != 11 {
+		t.Errorf("Expected wrapping to measure the strike's advance of 11, got %v", width)
+	}
+
+	// Advances come from the strike, measured or drawn.
+	m, err := NewMeasurer(Params{})
+	if err != nil {
+		t.Fatalf("Failed to create measurer: %v", err)
+	}
+	mfont, err := m.AddFontFromBytes("strike", data)
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	for s, handle := range map[*FontStash]int{fs: font, m: mfont} {
+		s.SetFont(handle)
+		s.SetSize(16)
+		var bounds [4]float32
+		if adv := s.TextBounds(10, 20, "AB", &bounds); adv != 11 {
+			t.Errorf("MeasureOnly %v: expected an advance of 11, got %v", s.Params.MeasureOnly, adv)
+		}
+		if got, want := bounds[3]-bounds[1], float32(3+2*(s.Params.GlyphPadding-1)); got != want {
+			t.Errorf("MeasureOnly %v: expected bounds %v high, got %v", s.Params.MeasureOnly, want, bounds)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package fontstash
+
+import "image"
+
+// downsample box-filters coverage rasterized at n times size down by n.
+func downsample(dr image.Rectangle, mask image.Image, maskp image.Point, n int) (image.Rectangle, *image.Alpha) {
+	sr := shrinkRect(dr, n)
+	dst := image.NewAlpha(image.Rect(0, 0, sr.Dx(), sr.Dy()))
+	for y := range sr.Dy() {
+		for x := range sr.Dx() {
+			sum := 0
+			for j := range n {
+				for i := range n {
+					p := image.Pt((sr.Min.X+x)*n+i, (sr.Min.Y+y)*n+j)
+					if !p.In(dr) {
+						continue
+					}
+					_, _, _, a := mask.At(maskp.X+p.X-dr.Min.X, maskp.Y+p.Y-dr.Min.Y).RGBA()
+					sum += int(a >> 8)
+				}
+			}
+			dst.Pix[y*dst.Stride+x] = uint8((sum + n*n/2) / (n * n))
+		}
+	}
+	return sr, dst
+}
+
+// shrinkRect returns the pixels at 1/n scale that r touches.
+func shrinkRect(r image.Rectangle, n int) image.Rectangle {
+	if r.Empty() {
+		return image.Rectangle{}
+	}
+	return image.Rect(floorDiv(r.Min.X, n), floorDiv(r.Min.Y, n), -floorDiv(-r.Max.X, n), -floorDiv(-r.Max.Y, n))
+}
+
+func floorDiv(a, n int) int {
+	q := a / n
+	if a%n != 0 && a < 0 {
+		q--
+	}
+	return q
+}
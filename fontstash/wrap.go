@@ -0,0 +1,226 @@
+package fontstash
+
+import (
+	"math"
+	"unicode/utf8"
+)
+
+// textLine is one visual line of wrapped text: str[start:end] is drawn,
+// with trailing whitespace trimmed, and advance is its width.
+type textLine struct {
+	start, end int
+	advance    float32
+}
+
+// normalizedString returns str converted to NFC if Params.Normalize is
+// set, so wrapping measures the same runes that are drawn.
+func (fs *FontStash) normalizedString(str string) string {
+	return fs.normalized(runeIter{s: str}).s
+}
+
+// wrapLines splits str into lines no wider than breakWidth.
+func (fs *FontStash) wrapLines(f *Font, state *State, str string, breakWidth float32) []textLine {
+	lb := fs.lineBreaker()
+	isize := fs.sizeKey(state.layoutSize())
+	iblur := int16(state.Blur)
+	spacing := state.spacing()
+	stretch := state.stretch()
+
+	var (
+		lines         []textLine
+		start, inkEnd int
+		x, inkAdvance float32
+		prev          Glyph
+		hasPrev       bool
+		prevRune      rune
+		breakAt       = -1
+		breakEnd      int
+		breakAdvance  float32
+	)
+	newLine := func(end int, advance float32, next int) {
+		lines = append(lines, textLine{start: start, end: end, advance: advance})
+		start, inkEnd, x, inkAdvance, hasPrev, breakAt = next, next, 0, 0, false, -1
+	}
+
+	for i := 0; i < len(str); {
+		r, n := utf8.DecodeRuneInString(str[i:])
+		if r == '\n' {
+			newLine(inkEnd, inkAdvance, i+n)
+			i += n
+			continue
+		}
+		if i > start && lb.CanBreakBefore(prevRune, r) {
+			breakAt, breakEnd, breakAdvance = i, inkEnd, inkAdvance
+		}
+
+		glyph, ok := fs.measureGlyph(f, r, isize, iblur, 0)
+		dropped := ok && fs.dropped(&glyph)
+		nx := x
+		if ok && !dropped {
+			if hasPrev {
+				nx = fs.kernPen(&prev, &glyph, stretch, spacing, nx)
+			}
+			nx = fs.advancePen(&glyph, stretch, nx)
+		}
+
+		if breakWidth > 0 && nx > breakWidth && !isSpace(r) && i > start {
+			if breakAt > start {
+				i = breakAt
+				newLine(breakEnd, breakAdvance, i)
+			} else {
+				// No break opportunity: split the word here.
+				newLine(inkEnd, inkAdvance, i)
+			}
+			continue
+		}
+
+		x = nx
+		if !dropped {
+			prev, hasPrev = glyph, ok
+		}
+		prevRune = r
+		i += n
+		if !isSpace(r) {
+			inkEnd, inkAdvance = i, x
+		}
+	}
+	newLine(inkEnd, inkAdvance, len(str))
+	return lines
+}
+
+// DrawTextBox draws str wrapped to lines no wider than breakWidth, with the
+// first baseline at y and following lines one line height further down the
+// page. Lines break at newlines and where Params.LineBreaker allows, and
+// each line is aligned horizontally by the current state on its own.
+// Wrapping applies to horizontal text only.
+func (fs *FontStash) DrawTextBox(x, y, breakWidth float32, str string) {
+	fs.drawTextBox(x, y, breakWidth, str)
+}
+
+// DrawTextWrapped draws str as DrawTextBox does and reports the layout:
+// the advance of each visual line, trailing whitespace excluded, and the
+// height of the stacked line boxes. Aligned lines start at x minus their
+// width, or half of it, so the widths are enough to draw selection
+// highlights behind them.
+func (fs *FontStash) DrawTextWrapped(x, y, breakWidth float32, str string) (lineWidths []float32, totalHeight float32) {
+	lines := fs.drawTextBox(x, y, breakWidth, str)
+	if lines == nil {
+		return nil, 0
+	}
+	lineWidths = make([]float32, len(lines))
+	for i, line := range lines {
+		lineWidths[i] = line.advance
+	}
+	miny, maxy := fs.linesExtent(y, len(lines))
+	return lineWidths, maxy - miny
+}
+
+// drawTextBox draws the wrapped lines of str and returns them, or nil if
+// there is no current font.
+func (fs *FontStash) drawTextBox(x, y, breakWidth float32, str string) []textLine {
+	state := fs.getState()
+	f := fs.getFont(state.Font)
+	if f == nil {
+		return nil
+	}
+	step := fs.lineStep(f, state)
+	str = fs.normalizedString(str)
+	lines := fs.wrapLines(f, state, str, breakWidth)
+	for _, line := range lines {
+		fs.drawText(x, y, runeIter{s: str[line.start:line.end], normal: true}, nil, nil)
+		y += step
+	}
+	return lines
+}
+
+// TextBoxBounds measures str as DrawTextBox would lay it out without
+// drawing or rasterizing anything. It returns the number of lines, the
+// widest line's advance, the height of the stacked line boxes, and bounds
+// enclosing every line box after alignment, as minx, miny, maxx, maxy.
+func (fs *FontStash) TextBoxBounds(x, y, breakWidth float32, str string) (lines int, width, height float32, bounds [4]float32) {
+	state := fs.getState()
+	f := fs.getFont(state.Font)
+	if f == nil {
+		return 0, 0, 0, [4]float32{x, y, x, y}
+	}
+	wrapped := fs.wrapLines(f, state, fs.normalizedString(str), breakWidth)
+
+	miny, maxy := fs.linesExtent(y, len(wrapped))
+	minx, maxx := x, x
+	for i, line := range wrapped {
+		lx := x
+		if state.Align&AlignLeft != 0 {
+			// empty
+		} else if state.Align&AlignRight != 0 {
+			lx -= line.advance
+		} else if state.Align&AlignCenter != 0 {
+			lx -= line.advance * 0.5
+		}
+		if i == 0 {
+			minx, maxx = lx, lx+line.advance
+		}
+		minx = min(minx, lx)
+		maxx = max(maxx, lx+line.advance)
+		width = max(width, line.advance)
+	}
+	return len(wrapped), width, maxy - miny, [4]float32{minx, miny, maxx, maxy}
+}
+
+// linesExtent returns the vertical span of n stacked line boxes of the
+// current font with the first baseline at y.
+func (fs *FontStash) linesExtent(y float32, n int) (miny, maxy float32) {
+	state := fs.getState()
+	miny, maxy = fs.LineBounds(y)
+	if f := fs.getFont(state.Font); f != nil && n > 1 {
+		lastMin, lastMax := fs.LineBounds(y + fs.lineStep(f, state)*float32(n-1))
+		miny, maxy = min(miny, lastMin), max(maxy, lastMax)
+	}
+	return miny, maxy
+}
+
+// lineStep returns the signed distance from one wrapped baseline to the
+// next, down the page in either Zero* convention.
+func (fs *FontStash) lineStep(f *Font, state *State) float32 {
+	return fs.ySign * f.LineHeight * state.layoutSize()
+}
+
+// DrawTextFit draws str like DrawText, first shrinking the font size in
+// steps of a tenth of a pixel until the text is no wider than maxWidth or
+// the smallest size glyphs are rendered at is reached. Widths come from
+// glyph metrics, so only the final size is rasterized. It returns the pen
+// x after the text and the size used; the state's size is left unchanged.
+func (fs *FontStash) DrawTextFit(x, y, maxWidth float32, str string) (float32, float32) {
+	state := fs.getState()
+	f := fs.getFont(state.Font)
+	if f == nil {
+		return x, state.Size
+	}
+	str = fs.normalizedString(str)
+	orig := state.Size
+	size := orig
+	for size*sizeScale > minFontSize {
+		state.Size = size
+		width := fs.lineWidth(f, state, str)
+		if width <= maxWidth {
+			break
+		}
+		// Width scales roughly with size, so jump close to the answer
+		// and step down from there.
+		next := float32(math.Floor(float64(size*maxWidth/width*sizeScale))) / sizeScale
+		size = max(min(next, size-1/sizeScale), minFontSize/sizeScale)
+	}
+	state.Size = size
+	x = fs.drawText(x, y, runeIter{s: str, normal: true}, nil, nil)
+	state.Size = orig
+	return x, size
+}
+
+// lineWidth returns the width of the widest line of str at the state's
+// size, with trailing whitespace trimmed.
+func (fs *FontStash) lineWidth(f *Font, state *State, str string) float32 {
+	var width float32
+	for _, line := range fs.wrapLines(f, state, str, 0) {
+		width = max(width, line.advance)
+	}
+	return width
+}
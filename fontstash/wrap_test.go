@@ -0,0 +1,175 @@
+package fontstash
+
+import "testing"
+
+func TestTextBoxBounds(t *testing.T) {
+	mock := &MockRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: mock, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	str := "The quick brown fox\njumps"
+	lines, width, height, bounds := fs.TextBoxBounds(10, 50, 100, str)
+	if n := len(fs.Fonts[fontNormal].Glyphs); n != 0 {
+		t.Errorf("Expected measuring not to rasterize, cached %d glyphs", n)
+	}
+
+	wrapped := fs.wrapLines(fs.Fonts[fontNormal], fs.getState(), str, 100)
+	var got []string
+	for _, l := range wrapped {
+		got = append(got, str[l.start:l.end])
+		if l.advance > 100 {
+			t.Errorf("Line %q is %f wide, past the break width", str[l.start:l.end], l.advance)
+		}
+		if want := fs.TextBounds(0, 0, str[l.start:l.end], nil); l.advance != want {
+			t.Errorf("Line %q measured %f, TextBounds gives %f", str[l.start:l.end], l.advance, want)
+		}
+	}
+	want := []string{"The quick", "brown fox", "jumps"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected lines %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected lines %q, got %q", want, got)
+		}
+	}
+
+	_, _, lineHeight := fs.VertMetrics()
+	if lines != 3 || width > 100 || width <= 0 {
+		t.Errorf("Unexpected %d lines of width %f", lines, width)
+	}
+	if want := 3 * lineHeight; height < want-0.01 || height > want+0.01 {
+		t.Errorf("Expected height %f, got %f", want, height)
+	}
+	if bounds[0] != 10 || bounds[2] != 10+width || bounds[3]-bounds[1] != height {
+		t.Errorf("Unexpected bounds %v", bounds)
+	}
+
+	fs.SetAlign(AlignRight | AlignBaseline)
+	if _, _, _, right := fs.TextBoxBounds(10, 50, 100, str); right[2] != 10 || right[0] != 10-width {
+		t.Errorf("Expected right aligned bounds to end at 10, got %v", right)
+	}
+
+	// A word longer than the break width is split between runes.
+	if n, _, _, _ := fs.TextBoxBounds(0, 0, 30, "abcdefghij"); n < 2 {
+		t.Errorf("Expected a long word to be split, got %d lines", n)
+	}
+}
+
+func TestDrawTextBox(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: rec, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	fs.DrawTextBox(10, 50, 100, "The quick brown fox")
+	if want := 16 * vertsPerQuad; len(rec.Vertices) != want {
+		t.Fatalf("Expected %d verts, got %d", want, len(rec.Vertices))
+	}
+	_, _, lineHeight := fs.VertMetrics()
+	// "b" starts the second line, one line height down.
+	first, second := rec.Vertices[0], rec.Vertices[8*vertsPerQuad]
+	if second.X >= first.X+5 || second.Y-first.Y < lineHeight*0.5 {
+		t.Errorf("Expected the second line below the first, got %+v and %+v", first, second)
+	}
+}
+
+func TestDrawTextFit(t *testing.T) {
+	fs, err := New(Params{Width: 512, Height: 256, Renderer: &MockRenderer{}})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(24)
+
+	const str = "Submit order"
+	full := fs.TextBounds(0, 0, str, nil)
+	if x, size := fs.DrawTextFit(10, 30, full+1, str); size != 24 || x != 10+full {
+		t.Errorf("Expected text that fits to draw at 24px to %v, got %v at %v", 10+full, size, x)
+	}
+
+	x, size := fs.DrawTextFit(10, 30, 80, str)
+	if estimate := 24 * 80 / full; size > estimate || size < estimate-1 {
+		t.Fatalf("Expected a size a little under %v, got %v", estimate, size)
+	}
+	if fs.getState().Size != 24 {
+		t.Errorf("Expected the state's size to be kept, got %v", fs.getState().Size)
+	}
+	fs.SetSize(size)
+	if w := fs.TextBounds(0, 0, str, nil); w > 80 || x != 10+w {
+		t.Errorf("Fitted text is %v wide and ends at %v", w, x)
+	}
+
+	if _, size := fs.DrawTextFit(10, 30, 1, str); size != minFontSize/sizeScale {
+		t.Errorf("Expected the minimum size when nothing fits, got %v", size)
+	}
+}
+
+func TestDrawTextWrapped(t *testing.T) {
+	rec := &recordingRenderer{}
+	fs, err := New(Params{Width: 256, Height: 256, Renderer: rec, Flags: ZeroTopLeft})
+	if err != nil {
+		t.Fatalf("Failed to create fontstash: %v", err)
+	}
+	fontNormal, err := fs.AddFont("sans", "testdata/DroidSerif-Regular.ttf")
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	fs.SetFont(fontNormal)
+	fs.SetSize(20)
+
+	const str = "The quick brown fox"
+	lineText := []string{"The quick", "brown fox"}
+	for _, tc := range []struct {
+		align int
+		shift float32
+	}{
+		{AlignLeft, 0},
+		{AlignCenter, 0.5},
+		{AlignRight, 1},
+	} {
+		fs.SetAlign(tc.align | AlignBaseline)
+		rec.Vertices = rec.Vertices[:0]
+		widths, height := fs.DrawTextWrapped(100, 50, 100, str)
+		if len(widths) != len(lineText) {
+			t.Fatalf("Align %v: expected %d lines, got %v", tc.align, len(lineText), widths)
+		}
+		_, _, boxHeight, _ := fs.TextBoxBounds(100, 50, 100, str)
+		if height != boxHeight {
+			t.Errorf("Align %v: expected height %v, got %v", tc.align, boxHeight, height)
+		}
+		for i, w := range widths {
+			fs.SetAlign(AlignLeft | AlignBaseline)
+			want := fs.TextBounds(0, 0, lineText[i], nil)
+			fs.SetAlign(tc.align | AlignBaseline)
+			if w != want {
+				t.Errorf("Align %v: line %q is %v wide, want %v", tc.align, lineText[i], w, want)
+			}
+			// Both lines have 8 visible glyphs; the first quad of each
+			// sits near where the aligned line starts.
+			start := 100 - w*tc.shift
+			if x := rec.Vertices[i*8*vertsPerQuad].X; x < start-1 || x > start+3 {
+				t.Errorf("Align %v: line %q starts at %v, want about %v", tc.align, lineText[i], x, start)
+			}
+		}
+	}
+}
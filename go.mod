@@ -2,6 +2,7 @@ module github.com/arran4/go-fontstash
 
 go 1.24.3
 
-require golang.org/x/image v0.35.0
-
-require golang.org/x/text v0.33.0 // indirect
+require (
+	golang.org/x/image v0.35.0
+	golang.org/x/text v0.33.0
+)